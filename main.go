@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin"
 	"github.com/kelseyhightower/envconfig"
@@ -18,10 +22,29 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Drone sends SIGTERM when a build is cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	p := plugin.New(cfg)
 
-	if err := p.Exec(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	// "models" lists what the credentials can use instead of running an analysis
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		if err := p.ListModels(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := p.Exec(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Cancelled: build was stopped before the analysis completed")
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		stop()
 		os.Exit(1)
 	}
 
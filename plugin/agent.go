@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// budgetExhaustedMessage is returned for tool calls made after the agent budget is spent
+const budgetExhaustedMessage = "tool budget exhausted; answer with the information gathered so far"
+
+// generateTurn produces the model's answer to req, running the tool loop in agent
+// mode and continuing answers that were cut off at the output token limit
+func (c *GeminiClient) generateTurn(ctx context.Context, req *GenerateContentRequest, models []string) (*GenerateResult, error) {
+	var (
+		result *GenerateResult
+		err    error
+	)
+	if c.config.Agent {
+		result, err = c.runAgent(ctx, req, models)
+	} else {
+		result, err = c.generateOnce(ctx, req, models)
+	}
+	if err != nil {
+		return result, err
+	}
+	return c.continueTruncated(ctx, req, models, result)
+}
+
+// runAgent executes the model's function calls against the workspace and sends
+// the results back until the model answers. When the step or token budget is
+// spent, pending calls are refused and a final answer is requested with function
+// calling disabled. The tool call turns are appended to req so follow-ups keep them.
+func (c *GeminiClient) runAgent(ctx context.Context, req *GenerateContentRequest, models []string) (*GenerateResult, error) {
+	cfg := c.config
+
+	workspace, err := NewWorkspace(cfg.Target, cfg.Debug)
+	if err != nil {
+		return nil, err
+	}
+	workspace.logger = c.logger
+	workspace.tokenCache = cfg.TokenCacheFile
+
+	// Restore function calling for later turns if the budget forced it off
+	toolConfig := req.ToolConfig
+	defer func() { req.ToolConfig = toolConfig }()
+
+	var usage *UsageStats
+	final := false
+	for step := 1; ; step++ {
+		result, err := c.generateOnce(ctx, req, models)
+		if result != nil {
+			// Later steps start at the model that answered, without retrying models that failed
+			models = modelsFrom(models, result.Usage.ModelID)
+			if usage == nil {
+				usage = result.Usage
+			} else {
+				usage.Add(result.Usage)
+			}
+			result.Usage = usage
+		}
+		if err != nil {
+			return result, err
+		}
+
+		calls := functionCalls(result.Turn)
+		if len(calls) == 0 {
+			return result, nil
+		}
+		if final {
+			// The tokens spent so far are still reported with the error
+			return result, fmt.Errorf("model requested tools after the agent budget was exhausted")
+		}
+
+		final = step > cfg.AgentMaxSteps || (cfg.AgentTokenBudget > 0 && usage.TotalTokens >= cfg.AgentTokenBudget)
+
+		responses := make([]Part, 0, len(calls))
+		for _, call := range calls {
+			resp := FunctionResponse{ID: call.ID, Name: call.Name, Response: map[string]any{"error": budgetExhaustedMessage}}
+			if !final {
+				resp = workspace.Call(ctx, call)
+			}
+			responses = append(responses, Part{FunctionResponse: &resp})
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		req.Contents = append(req.Contents, result.Turn, Content{Role: "user", Parts: responses})
+		req.promptTokens = nil
+
+		if final {
+			c.logger.Printf("[TOOL] Budget exhausted after %d steps (%d tokens), requesting final answer\n", step-1, usage.TotalTokens)
+			req.ToolConfig = &ToolConfig{FunctionCallingConfig: &FunctionCallingConfig{Mode: "NONE"}}
+		}
+	}
+}
+
+// functionCalls returns the function calls of a model turn
+func functionCalls(turn Content) []FunctionCall {
+	var calls []FunctionCall
+	for _, part := range turn.Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, *part.FunctionCall)
+		}
+	}
+	return calls
+}
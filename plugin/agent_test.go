@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestExec_Agent(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.FunctionCallResponse("read_file", map[string]any{"path": "main.go"}),
+		geminitest.TextResponse("main.go looks fine.", 1000),
+	)
+
+	cfg := execConfig(t)
+	cfg.Agent = true
+	cfg.AgentMaxSteps = 5
+
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(requests) != 2 {
+		t.Fatalf("generateContent requests = %d, want 2", len(requests))
+	}
+
+	var first, second GenerateContentRequest
+	if err := requests[0].JSON(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := requests[1].JSON(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first.Tools) != 1 || len(first.Tools[0].FunctionDeclarations) != len(workspaceTools) {
+		t.Errorf("tools = %+v, want the workspace tools", first.Tools)
+	}
+	if len(second.Contents) != 3 {
+		t.Fatalf("contents = %d, want prompt, function call and function response", len(second.Contents))
+	}
+	call := second.Contents[1].Parts[0].FunctionCall
+	if second.Contents[1].Role != "model" || call == nil || call.Name != "read_file" {
+		t.Errorf("model turn = %+v, want the read_file call", second.Contents[1])
+	}
+	resp := second.Contents[2].Parts[0].FunctionResponse
+	if resp == nil || resp.Name != "read_file" || resp.Response["output"] != "package main\n" {
+		t.Errorf("function response = %+v, want the contents of main.go", resp)
+	}
+	if second.ToolConfig != nil {
+		t.Errorf("toolConfig = %+v, want function calling left enabled", second.ToolConfig)
+	}
+}
+
+func TestExec_AgentBudgetExhausted(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.FunctionCallResponse("list_directory", map[string]any{}),
+		geminitest.FunctionCallResponse("read_file", map[string]any{"path": "main.go"}),
+		geminitest.TextResponse("Reviewed the listing only.", 1000),
+	)
+
+	cfg := execConfig(t)
+	cfg.Agent = true
+	cfg.AgentMaxSteps = 1
+
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(requests) != 3 {
+		t.Fatalf("generateContent requests = %d, want 3", len(requests))
+	}
+
+	var last GenerateContentRequest
+	if err := requests[2].JSON(&last); err != nil {
+		t.Fatal(err)
+	}
+	if last.ToolConfig == nil || last.ToolConfig.FunctionCallingConfig == nil || last.ToolConfig.FunctionCallingConfig.Mode != "NONE" {
+		t.Errorf("toolConfig = %+v, want function calling disabled", last.ToolConfig)
+	}
+	resp := last.Contents[len(last.Contents)-1].Parts[0].FunctionResponse
+	if resp == nil {
+		t.Fatal("last turn has no function response")
+	}
+	if msg, _ := resp.Response["error"].(string); !strings.Contains(msg, "budget exhausted") {
+		t.Errorf("function response = %+v, want the budget error", resp.Response)
+	}
+}
+
+func TestReviewer_AgentToolsAfterBudget(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.FunctionCallResponse("list_directory", map[string]any{}),
+		geminitest.FunctionCallResponse("read_file", map[string]any{"path": "main.go"}),
+		geminitest.FunctionCallResponse("grep", map[string]any{"pattern": "main"}),
+	)
+
+	cfg := execConfig(t)
+	cfg.Agent = true
+	cfg.AgentMaxSteps = 1
+
+	reviewer, err := NewReviewer(WithConfig(cfg), WithClientOptions(WithTransport(srv.Transport())))
+	if err != nil {
+		t.Fatalf("NewReviewer() unexpected error: %v", err)
+	}
+	result, err := reviewer.Review(context.Background(), cfg.Prompt)
+	if err == nil || !strings.Contains(err.Error(), "budget") {
+		t.Fatalf("Review() error = %v, want the budget error", err)
+	}
+	if result == nil || result.Usage == nil || result.Usage.TotalTokens != 330 {
+		t.Errorf("Review() result = %+v, want the usage of all three steps (330 tokens)", result)
+	}
+}
+
+func TestGeminiClient_AgentModelFallback(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.ErrorResponse(429, "RESOURCE_EXHAUSTED", "quota"),
+		geminitest.FunctionCallResponse("read_file", map[string]any{"path": "main.go"}),
+		geminitest.TextResponse("main.go looks fine.", 1000),
+	)
+
+	cfg := execConfig(t)
+	cfg.Model = "gemini-2.5-pro,gemini-2.5-flash"
+	cfg.RetryMaxAttempts = 1
+	cfg.Agent = true
+	cfg.AgentMaxSteps = 5
+
+	result, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	var models []string
+	for _, req := range srv.RequestsFor(geminitest.MethodGenerateContent) {
+		models = append(models, req.Model)
+	}
+	if got := strings.Join(models, ","); got != "gemini-2.5-pro,gemini-2.5-flash,gemini-2.5-flash" {
+		t.Errorf("requested models = %s, want the failed model skipped after the first step", got)
+	}
+	if len(result.Usage.SkippedModels) != 1 {
+		t.Errorf("SkippedModels = %v, want 1 entry", result.Usage.SkippedModels)
+	}
+}
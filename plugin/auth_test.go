@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTokenSource issues numbered tokens valid for lifetime
+func countingTokenSource(calls *atomic.Int32, lifetime time.Duration) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		n := calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return &Token{AccessToken: string(rune('a' + n - 1)), Expiry: time.Now().Add(lifetime)}, nil
+	})
+}
+
+func TestToken_Valid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *Token
+		want  bool
+	}{
+		{name: "nil", token: nil, want: false},
+		{name: "empty", token: &Token{Expiry: time.Now().Add(time.Hour)}, want: false},
+		{name: "fresh", token: &Token{AccessToken: "t", Expiry: time.Now().Add(time.Hour)}, want: true},
+		{name: "about to expire", token: &Token{AccessToken: "t", Expiry: time.Now().Add(time.Minute)}, want: false},
+		{name: "no expiry", token: &Token{AccessToken: "t"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.Valid(); got != tt.want {
+				t.Errorf("Valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	var calls atomic.Int32
+	tokens := NewCachingTokenSource(countingTokenSource(&calls, time.Hour), "", "key")
+
+	// Concurrent callers share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := tokens.Token(context.Background()); err != nil || token.AccessToken != "a" {
+				t.Errorf("Token() = %v, %v, want the first token", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("base calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestCachingTokenSource_Refresh(t *testing.T) {
+	var calls atomic.Int32
+	tokens := NewCachingTokenSource(countingTokenSource(&calls, 2*time.Minute), "", "key")
+
+	for i := 0; i < 2; i++ {
+		if _, err := tokens.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("base calls = %d, want a refresh for tokens within the expiry margin", calls.Load())
+	}
+}
+
+func TestCachingTokenSource_WaitCancelled(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	tokens := NewCachingTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		close(started)
+		<-release
+		return &Token{AccessToken: "slow", Expiry: time.Now().Add(time.Hour)}, nil
+	}), "", "key")
+
+	// A hung refresh does not block callers past their own deadline
+	first := make(chan *Token)
+	go func() {
+		token, _ := tokens.Token(context.Background())
+		first <- token
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tokens.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Token() error = %v, want the caller's deadline", err)
+	}
+
+	close(release)
+	if token := <-first; token == nil || token.AccessToken != "slow" {
+		t.Errorf("first Token() = %v, want the refreshed token", token)
+	}
+}
+
+func TestCachingTokenSource_RetryCancelledRefresh(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	tokens := NewCachingTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &Token{AccessToken: "second", Expiry: time.Now().Add(time.Hour)}, nil
+	}), "", "key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go tokens.Token(ctx)
+	<-started
+
+	// The waiting caller fetches again when the first caller gives up
+	result := make(chan *Token)
+	go func() {
+		token, _ := tokens.Token(context.Background())
+		result <- token
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if token := <-result; token == nil || token.AccessToken != "second" {
+		t.Errorf("Token() = %v, want a token from a new fetch", token)
+	}
+}
+
+func TestCachingTokenSource_Error(t *testing.T) {
+	errBase := errors.New("exchange failed")
+	tokens := NewCachingTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return nil, errBase
+	}), "", "key")
+
+	if _, err := tokens.Token(context.Background()); !errors.Is(err, errBase) {
+		t.Errorf("Token() error = %v, want the base error", err)
+	}
+}
+
+func TestCachingTokenSource_File(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "token.json")
+	var calls atomic.Int32
+
+	if _, err := NewCachingTokenSource(countingTokenSource(&calls, time.Hour), cacheFile, "key").Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(cacheFile)
+	if err != nil {
+		t.Fatalf("cache file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("cache file mode = %o, want 0600", perm)
+	}
+
+	// A later process with the same credentials reuses the token
+	token, err := NewCachingTokenSource(countingTokenSource(&calls, time.Hour), cacheFile, "key").Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "a" || calls.Load() != 1 {
+		t.Errorf("token = %q after %d calls, want the cached token", token.AccessToken, calls.Load())
+	}
+
+	// Other credentials ignore it
+	token, err = NewCachingTokenSource(countingTokenSource(&calls, time.Hour), cacheFile, "other").Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "b" {
+		t.Errorf("token = %q, want a new token for other credentials", token.AccessToken)
+	}
+
+	// Single-use tokens without a lifetime are not persisted
+	singleUse := filepath.Join(t.TempDir(), "single-use.json")
+	if _, err := NewCachingTokenSource(countingTokenSource(&calls, 0), singleUse, "key").Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(singleUse); !os.IsNotExist(err) {
+		t.Errorf("cache file for a single-use token: %v, want none written", err)
+	}
+}
+
+func TestBuildContext_SkipsTokenCache(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":    "package main\n",
+		"token.json": `{"access_token":"secret"}`,
+	})
+
+	cfg := &Config{Target: dir, TokenCacheFile: filepath.Join(dir, "token.json")}
+	_, files, err := NewGeminiClient(cfg).buildContext(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "main.go" {
+		t.Errorf("files = %v, want the token cache excluded", files)
+	}
+}
+
+func TestWorkspace_SkipsTokenCache(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":    "package main\n",
+		"token.json": `{"access_token":"secret"}`,
+	})
+
+	w, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.tokenCache = filepath.Join(dir, "token.json")
+
+	calls := []FunctionCall{
+		{Name: "read_file", Args: map[string]any{"path": "token.json"}},
+		{Name: "list_directory", Args: map[string]any{}},
+		{Name: "grep", Args: map[string]any{"pattern": "access_token|package"}},
+	}
+	for _, call := range calls {
+		resp := w.Call(context.Background(), call)
+		if output, _ := resp.Response["output"].(string); strings.Contains(output, "token.json") || strings.Contains(output, "secret") {
+			t.Errorf("%s output = %q, want the token cache excluded", call.Name, output)
+		}
+		if call.Name == "read_file" && resp.Response["error"] == nil {
+			t.Errorf("read_file(token.json) = %+v, want an error", resp.Response)
+		}
+	}
+}
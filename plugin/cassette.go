@@ -0,0 +1,338 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces secrets in cassettes
+const redactedValue = "REDACTED"
+
+// sensitiveFields are query parameters, form fields and JSON keys that never
+// reach a cassette: API keys, JWT assertions, tokens and private keys
+var sensitiveFields = map[string]bool{
+	"key":           true,
+	"assertion":     true,
+	"access_token":  true,
+	"accessToken":   true,
+	"id_token":      true,
+	"refresh_token": true,
+	"client_secret": true,
+	"subject_token": true,
+	"private_key":   true,
+}
+
+// Cassette is a recorded API interaction stored as JSON. Identical requests
+// (e.g. retries) share one cassette and are answered in recording order.
+type Cassette struct {
+	Request   CassetteRequest    `json:"request"`
+	Responses []CassetteResponse `json:"responses"`
+}
+
+// CassetteRequest is a redacted recorded request
+type CassetteRequest struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// CassetteResponse is a recorded response
+type CassetteResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// cassetteHeaders are the response headers kept in cassettes
+var cassetteHeaders = []string{"Content-Type", "Retry-After"}
+
+// RecordingTransport forwards requests and stores each interaction in dir
+type RecordingTransport struct {
+	dir  string
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	cassettes map[string]*Cassette
+	logger    Logger
+}
+
+// NewRecordingTransport records interactions of base into cassette files in dir
+func NewRecordingTransport(dir string, base http.RoundTripper) *RecordingTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RecordingTransport{dir: dir, base: base, cassettes: make(map[string]*Cassette), logger: defaultLogger}
+}
+
+// RoundTrip sends the request and records it with its response
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Work on a copy since reading the body for the cassette replaces it
+	req = req.Clone(req.Context())
+	recorded, name, err := newCassetteRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the body as the caller reads it so streamed responses still stream
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		logger:     t.logger,
+		onClose: func(body []byte) error {
+			return t.save(name, recorded, newCassetteResponse(resp, body))
+		},
+	}
+	return resp, nil
+}
+
+// save appends a response to the cassette and writes it to disk
+func (t *RecordingTransport) save(name string, req CassetteRequest, resp CassetteResponse) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cassette, ok := t.cassettes[name]
+	if !ok {
+		cassette = &Cassette{Request: req}
+		t.cassettes[name] = cassette
+	}
+	cassette.Responses = append(cassette.Responses, resp)
+
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create record directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, name), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// recordingBody buffers what is read and hands it to onClose
+type recordingBody struct {
+	io.ReadCloser
+	buf     bytes.Buffer
+	onClose func([]byte) error
+	closed  bool
+	logger  Logger
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+	if saveErr := b.onClose(b.buf.Bytes()); saveErr != nil {
+		b.logger.Printf("[WARN] %v\n", saveErr)
+	}
+	return err
+}
+
+// ReplayTransport answers requests from cassette files without network access
+type ReplayTransport struct {
+	dir string
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+// NewReplayTransport serves responses recorded in dir
+func NewReplayTransport(dir string) *ReplayTransport {
+	return &ReplayTransport{dir: dir, served: make(map[string]int)}
+}
+
+// RoundTrip returns the recorded response for the request
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, name, err := newCassetteRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(t.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s (%s)", ErrCassetteNotFound, recorded.Method, recorded.URL, name)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil || len(cassette.Responses) == 0 {
+		return nil, fmt.Errorf("%w: %s is not a valid cassette", ErrCassetteNotFound, name)
+	}
+
+	// Serve recorded responses in order, repeating the last one
+	t.mu.Lock()
+	index := t.served[name]
+	t.served[name]++
+	t.mu.Unlock()
+	if index >= len(cassette.Responses) {
+		index = len(cassette.Responses) - 1
+	}
+	recordedResp := cassette.Responses[index]
+
+	header := http.Header{}
+	for key, value := range recordedResp.Header {
+		header.Set(key, value)
+	}
+	return &http.Response{
+		StatusCode: recordedResp.Status,
+		Status:     fmt.Sprintf("%d %s", recordedResp.Status, http.StatusText(recordedResp.Status)),
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(decodeCassetteBody(recordedResp.Body))),
+		Request:    req,
+	}, nil
+}
+
+// newCassetteRequest redacts the request and returns it with its cassette file name,
+// derived from the API method and a hash of method, URL and body
+func newCassetteRequest(req *http.Request) (CassetteRequest, string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return CassetteRequest{}, "", fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	redactedURL := *req.URL
+	redactedURL.RawQuery = redactValues(req.URL.Query()).Encode()
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			body = []byte(redactValues(form).Encode())
+		}
+	} else {
+		body = redactJSON(body)
+	}
+
+	recorded := CassetteRequest{
+		Method: req.Method,
+		URL:    redactedURL.String(),
+		Body:   encodeCassetteBody(body),
+	}
+
+	sum := sha256.Sum256([]byte(recorded.Method + "\n" + recorded.URL + "\n" + string(body)))
+	name := fmt.Sprintf("%s-%s.json", apiMethodName(req.URL.Path), hex.EncodeToString(sum[:8]))
+	return recorded, name, nil
+}
+
+// newCassetteResponse redacts a response for storage
+func newCassetteResponse(resp *http.Response, body []byte) CassetteResponse {
+	recorded := CassetteResponse{
+		Status: resp.StatusCode,
+		Body:   encodeCassetteBody(redactJSON(body)),
+	}
+	for _, key := range cassetteHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			if recorded.Header == nil {
+				recorded.Header = make(map[string]string)
+			}
+			recorded.Header[key] = value
+		}
+	}
+	return recorded
+}
+
+// apiMethodName returns a readable cassette prefix, e.g. generateContent or token
+func apiMethodName(urlPath string) string {
+	name := path.Base(urlPath)
+	if _, method, ok := strings.Cut(name, ":"); ok {
+		name = method
+	}
+	if name == "" || name == "/" || name == "." {
+		return "request"
+	}
+	return name
+}
+
+// redactValues replaces sensitive query or form values
+func redactValues(values url.Values) url.Values {
+	for key := range values {
+		if sensitiveFields[key] {
+			values[key] = []string{redactedValue}
+		}
+	}
+	return values
+}
+
+// redactJSON replaces sensitive fields of a JSON document, leaving other data unchanged
+func redactJSON(data []byte) []byte {
+	var doc any
+	if len(data) == 0 || json.Unmarshal(data, &doc) != nil || !redactJSONValue(doc) {
+		return data
+	}
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+// redactJSONValue redacts sensitive keys in place and reports whether anything changed
+func redactJSONValue(value any) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if sensitiveFields[key] {
+				v[key] = redactedValue
+				changed = true
+			} else if redactJSONValue(item) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if redactJSONValue(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// encodeCassetteBody stores JSON bodies as-is for readability, other bodies as strings
+func encodeCassetteBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	encoded, _ := json.Marshal(string(body))
+	return encoded
+}
+
+// decodeCassetteBody reverses encodeCassetteBody
+func decodeCassetteBody(raw json.RawMessage) []byte {
+	var text string
+	if len(raw) > 0 && raw[0] == '"' && json.Unmarshal(raw, &text) == nil {
+		return []byte(text)
+	}
+	return raw
+}
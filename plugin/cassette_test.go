@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.SetText("Recorded review")
+	srv.Enqueue(geminitest.MethodGenerateContent, geminitest.ErrorResponse(http.StatusServiceUnavailable, "UNAVAILABLE", "overloaded"))
+
+	creds, err := geminitest.ServiceAccountJSON("my-project", srv.TokenURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cfg := Config{
+		Prompt:           "Review",
+		GCPCredentials:   creds,
+		GCPProject:       "my-project",
+		GCPLocation:      "us-central1",
+		Model:            "gemini-2.5-flash",
+		Target:           writeTestFiles(t, map[string]string{"main.go": "package main\n"}),
+		RetryMaxAttempts: 2,
+		RetryBaseDelay:   1,
+		RecordDir:        dir,
+	}
+
+	recorded, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() while recording: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+		data, _ := os.ReadFile(file)
+		for _, secret := range []string{geminitest.DefaultAccessToken, "PRIVATE KEY", "assertion=ey"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains secret %q", filepath.Base(file), secret)
+			}
+		}
+	}
+	if len(files) != 2 || !strings.HasPrefix(names[0], "generateContent-") || !strings.HasPrefix(names[1], "token-") {
+		t.Fatalf("cassettes = %v, want one generateContent and one token cassette", names)
+	}
+
+	// Replay without network: the 503 and the answer are served in recording order
+	cfg.RecordDir = ""
+	cfg.ReplayDir = dir
+	offline := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected network request to %s", req.URL)
+		return nil, errors.New("offline")
+	})
+
+	replayed, err := NewGeminiClient(&cfg, WithTransport(offline)).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() while replaying: %v", err)
+	}
+	if replayed.Text != recorded.Text || replayed.Text != "Recorded review" {
+		t.Errorf("replayed text = %q, want %q", replayed.Text, recorded.Text)
+	}
+
+	// A changed prompt has no cassette
+	cfg.Prompt = "Review again"
+	_, err = NewGeminiClient(&cfg).GenerateContent(context.Background())
+	if !errors.Is(err, ErrCassetteNotFound) {
+		t.Errorf("GenerateContent() error = %v, want ErrCassetteNotFound", err)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	input := `{"access_token":"ya29.secret","expires_in":3600,"nested":[{"accessToken":"secret"}]}`
+	got := string(redactJSON([]byte(input)))
+	if strings.Contains(got, "secret") || !strings.Contains(got, `"expires_in":3600`) {
+		t.Errorf("redactJSON() = %s, want tokens redacted and other fields kept", got)
+	}
+
+	unchanged := `{"contents":[{"parts":[{"text":"hi"}]}]}`
+	if got := string(redactJSON([]byte(unchanged))); got != unchanged {
+		t.Errorf("redactJSON() = %s, want the document unchanged", got)
+	}
+}
+
+func TestConfig_RecordReplayConflict(t *testing.T) {
+	cfg := Config{Prompt: "Review", APIKey: "test-key", RecordDir: "a", ReplayDir: "b"}
+	if err := cfg.Validate(); !errors.Is(err, ErrRecordReplayConflict) {
+		t.Errorf("Validate() error = %v, want ErrRecordReplayConflict", err)
+	}
+}
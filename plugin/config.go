@@ -1,15 +1,35 @@
 package plugin
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
 // Config holds the plugin configuration from environment variables.
 // Drone CI injects these as PLUGIN_* environment variables.
 type Config struct {
-	// Prompt is the instruction for the AI (required)
-	Prompt string `envconfig:"PROMPT" required:"true"`
+	// Prompt is the instruction for the AI (required, checked by Validate)
+	Prompt string `envconfig:"PROMPT"`
+
+	// SystemInstruction sets the reviewer persona and team rules, sent as systemInstruction
+	SystemInstruction string `envconfig:"SYSTEM_INSTRUCTION"`
+
+	// SystemInstructionFile reads the system instruction from a file instead
+	SystemInstructionFile string `envconfig:"SYSTEM_INSTRUCTION_FILE"`
+
+	// FollowUps are prompts sent after the first answer as further turns of the
+	// same conversation, as a JSON array or one prompt per line
+	FollowUps StringList `envconfig:"FOLLOW_UPS"`
 
 	// Target is the file or directory to scan (optional, defaults to ".")
 	Target string `envconfig:"TARGET" default:"."`
 
-	// Model specifies which AI model to use (default: gemini-2.5-pro for 1M context)
+	// Model specifies which AI model to use (default: gemini-2.5-pro for 1M context).
+	// A comma-separated list is an ordered fallback chain, e.g.
+	// "gemini-3-pro-preview,gemini-2.5-pro,gemini-2.5-flash".
 	Model string `envconfig:"MODEL" default:"gemini-2.5-pro"`
 
 	// APIKey for Google AI Studio authentication (Scenario A)
@@ -18,18 +38,148 @@ type Config struct {
 	// GCPCredentials is the raw JSON credentials string for Vertex AI (Scenario B)
 	GCPCredentials string `envconfig:"GCP_CREDENTIALS"`
 
+	// GCPCredentialsFile is the path of a credentials file, instead of GCPCredentials
+	GCPCredentialsFile string `envconfig:"GCP_CREDENTIALS_FILE"`
+
+	// SubjectToken is the OIDC token exchanged by external_account (Workload
+	// Identity Federation) credentials, instead of their credential_source
+	SubjectToken string `envconfig:"SUBJECT_TOKEN"`
+
+	// ImpersonateServiceAccount is a service account email whose access token,
+	// issued by the IAM Credentials API, is used for Vertex AI requests
+	ImpersonateServiceAccount string `envconfig:"IMPERSONATE_SERVICE_ACCOUNT"`
+
+	// ImpersonateDelegates are service accounts that pass the impersonation
+	// along, in order, from the base credentials to ImpersonateServiceAccount
+	ImpersonateDelegates []string `envconfig:"IMPERSONATE_DELEGATES"`
+
 	// GCPProject is the Google Cloud project ID for Vertex AI
 	GCPProject string `envconfig:"GCP_PROJECT"`
 
 	// GCPLocation is the Google Cloud location for Vertex AI (e.g., us-central1)
 	GCPLocation string `envconfig:"GCP_LOCATION" default:"us-central1"`
 
+	// APIEndpoint overrides the API base URL (e.g., an internal API gateway)
+	APIEndpoint string `envconfig:"API_ENDPOINT"`
+
+	// TokenEndpoint overrides the OAuth token URL from the service account credentials
+	TokenEndpoint string `envconfig:"TOKEN_ENDPOINT"`
+
+	// IAMEndpoint overrides the IAM Credentials base URL used for service account impersonation
+	IAMEndpoint string `envconfig:"IAM_ENDPOINT"`
+
+	// TokenCacheFile persists the Vertex AI access token so later steps of the
+	// same build can reuse it until it expires (written with mode 0600)
+	TokenCacheFile string `envconfig:"TOKEN_CACHE_FILE"`
+
+	// Temperature controls randomness of the output (0.0 - 2.0, model default if unset)
+	Temperature *float64 `envconfig:"TEMPERATURE"`
+
+	// TopP is the nucleus sampling probability mass (0.0 - 1.0)
+	TopP *float64 `envconfig:"TOP_P"`
+
+	// TopK limits sampling to the K most likely tokens
+	TopK *int `envconfig:"TOP_K"`
+
+	// CandidateCount is the number of response candidates to generate (0 = model default)
+	CandidateCount int `envconfig:"CANDIDATE_COUNT"`
+
+	// MaxOutputTokens caps the response length in tokens (0 = model default)
+	MaxOutputTokens int `envconfig:"MAX_OUTPUT_TOKENS"`
+
+	// MaxContinuations limits the requests sent to continue an answer cut off
+	// at the output token limit (0 = return the truncated answer)
+	MaxContinuations int `envconfig:"MAX_CONTINUATIONS" default:"3"`
+
+	// StopSequences stops generation at any of these strings (comma-separated, up to 5)
+	StopSequences []string `envconfig:"STOP_SEQUENCES"`
+
+	// Seed makes sampling reproducible across runs where the model supports it
+	Seed *int `envconfig:"SEED"`
+
+	// PresencePenalty penalizes tokens that already appeared in the output (-2.0 - 2.0)
+	PresencePenalty *float64 `envconfig:"PRESENCE_PENALTY"`
+
+	// FrequencyPenalty penalizes tokens proportionally to how often they appeared (-2.0 - 2.0)
+	FrequencyPenalty *float64 `envconfig:"FREQUENCY_PENALTY"`
+
+	// ThinkingBudget sets the thinking token budget for Gemini 2.5 models (-1 = dynamic, 0 = off)
+	ThinkingBudget *int `envconfig:"THINKING_BUDGET"`
+
+	// ThinkingLevel sets the reasoning depth for Gemini 3 models (minimal, low, medium, high)
+	ThinkingLevel string `envconfig:"THINKING_LEVEL"`
+
+	// IncludeThoughts requests thought summaries, printed in a separate Reasoning section
+	IncludeThoughts bool `envconfig:"INCLUDE_THOUGHTS" default:"false"`
+
+	// ResponseMimeType sets the response format (text/plain or application/json)
+	ResponseMimeType string `envconfig:"RESPONSE_MIME_TYPE"`
+
+	// ResponseSchema is a JSON Schema file path, or "findings" for the built-in review schema.
+	// Implies application/json; the response is validated against it.
+	ResponseSchema string `envconfig:"RESPONSE_SCHEMA"`
+
+	// SafetySettings sets harm category thresholds as category=threshold pairs
+	// (e.g. dangerous_content=only_high,harassment=none)
+	SafetySettings []string `envconfig:"SAFETY_SETTINGS"`
+
+	// Stream prints the response incrementally using streamGenerateContent
+	Stream bool `envconfig:"STREAM" default:"false"`
+
 	// Debug enables debug output
 	Debug bool `envconfig:"DEBUG" default:"false"`
 
 	// Timeout in seconds for API calls (default 300s = 5 minutes)
 	Timeout int `envconfig:"TIMEOUT" default:"300"`
 
+	// Proxy is the HTTP(S) proxy URL for outbound calls (default: HTTPS_PROXY from the environment)
+	Proxy string `envconfig:"PROXY"`
+
+	// CABundle adds trusted root CAs, as a file path or inline PEM content
+	CABundle string `envconfig:"CA_BUNDLE"`
+
+	// ClientCert is the mTLS client certificate, as a file path or inline PEM content
+	ClientCert string `envconfig:"CLIENT_CERT"`
+
+	// ClientKey is the mTLS client private key, as a file path or inline PEM content
+	ClientKey string `envconfig:"CLIENT_KEY"`
+
+	// Agent lets the model call read-only workspace tools (read_file, list_directory,
+	// grep, git_show) to fetch files that did not fit in the context
+	Agent bool `envconfig:"AGENT"`
+
+	// AgentMaxSteps limits the rounds of tool calls executed before a final answer is required
+	AgentMaxSteps int `envconfig:"AGENT_MAX_STEPS" default:"10"`
+
+	// AgentTokenBudget stops tool use once the run has used this many tokens (0 = no limit)
+	AgentTokenBudget int `envconfig:"AGENT_TOKEN_BUDGET"`
+
+	// CodeExecution enables the built-in code execution tool, letting the model
+	// run Python to check regexes, arithmetic or small algorithms
+	CodeExecution bool `envconfig:"CODE_EXECUTION"`
+
+	// URLContext enables the built-in URL context tool, letting the model fetch
+	// URLs mentioned in the prompt or code
+	URLContext bool `envconfig:"URL_CONTEXT"`
+
+	// RecordDir stores every API interaction as a cassette file in this directory (secrets redacted)
+	RecordDir string `envconfig:"RECORD_DIR"`
+
+	// ReplayDir serves API responses from cassettes in this directory without network access
+	ReplayDir string `envconfig:"REPLAY_DIR"`
+
+	// RetryMaxAttempts is the total number of attempts for each API call (1 = no retries)
+	RetryMaxAttempts int `envconfig:"RETRY_MAX_ATTEMPTS" default:"3"`
+
+	// RetryBaseDelay is the delay before the first retry in milliseconds, doubled on each retry
+	RetryBaseDelay int `envconfig:"RETRY_BASE_DELAY" default:"1000"`
+
+	// RetryMaxDelay caps the backoff delay in milliseconds
+	RetryMaxDelay int `envconfig:"RETRY_MAX_DELAY" default:"30000"`
+
+	// RetryJitter is the fraction of the backoff delay to randomize (0.0 - 1.0)
+	RetryJitter float64 `envconfig:"RETRY_JITTER" default:"0.2"`
+
 	// GitDiff enables analyzing the last commit diff
 	GitDiff bool `envconfig:"GIT_DIFF" default:"false"`
 
@@ -41,6 +191,55 @@ type Config struct {
 
 	// MaxContextSize limits total context size in bytes (default 500KB)
 	MaxContextSize int `envconfig:"MAX_CONTEXT_SIZE" default:"512000"`
+
+	// credentials found by ResolveCredentials
+	credentials *Credentials
+}
+
+// StringList is a list setting whose items may contain commas, unlike []string
+// settings. It is decoded from a JSON array or from one item per line
+// (e.g. a YAML block scalar).
+type StringList []string
+
+// Decode implements envconfig.Decoder
+func (l *StringList) Decode(value string) error {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		var items []string
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return fmt.Errorf("invalid JSON list: %w", err)
+		}
+		*l = items
+		return nil
+	}
+
+	var items []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	*l = items
+	return nil
+}
+
+// DefaultConfig returns the configuration used when no PLUGIN_* variables are
+// set; it mirrors the default tags above for library use without envconfig
+func DefaultConfig() Config {
+	return Config{
+		Target:           ".",
+		Model:            "gemini-2.5-pro",
+		GCPLocation:      "us-central1",
+		MaxContinuations: 3,
+		Timeout:          300,
+		AgentMaxSteps:    10,
+		RetryMaxAttempts: 3,
+		RetryBaseDelay:   1000,
+		RetryMaxDelay:    30000,
+		RetryJitter:      0.2,
+		MaxFiles:         50,
+		MaxContextSize:   512000,
+	}
 }
 
 // AuthMode represents the authentication mode detected from configuration
@@ -54,27 +253,51 @@ const (
 
 // DetectAuthMode automatically detects which authentication mode to use
 // - APIKey alone = Google AI Studio (simplest)
-// - GCPCredentials + GCPProject = Vertex AI with Service Account (enterprise)
+// - GCPCredentials(File), or credentials found by ResolveCredentials = Vertex AI (enterprise)
 func (c *Config) DetectAuthMode() AuthMode {
 	// Scenario A: API Key (Google AI Studio) - simplest option
 	if c.APIKey != "" {
 		return AuthModeAPIKey
 	}
 
-	// Scenario B: Vertex AI with Service Account credentials
-	if c.GCPCredentials != "" && c.GCPProject != "" {
+	// Scenario B: Vertex AI with service account or Application Default Credentials
+	if c.GCPCredentials != "" || c.GCPCredentialsFile != "" || c.credentials != nil {
 		return AuthModeVertexAI
 	}
 
 	return AuthModeNone
 }
 
+// Models returns the model fallback chain in order of preference
+func (c *Config) Models() []string {
+	var models []string
+	for _, model := range strings.Split(c.Model, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// PrimaryModel returns the first model of the fallback chain
+func (c *Config) PrimaryModel() string {
+	if models := c.Models(); len(models) > 0 {
+		return models[0]
+	}
+	return ""
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.Prompt == "" {
 		return ErrPromptRequired
 	}
+	return c.validateSettings()
+}
 
+// validateSettings checks everything but the prompt, which a Reviewer
+// receives per review
+func (c *Config) validateSettings() error {
 	authMode := c.DetectAuthMode()
 	if authMode == AuthModeNone {
 		return ErrNoCredentials
@@ -84,5 +307,170 @@ func (c *Config) Validate() error {
 		return ErrProjectRequired
 	}
 
+	if c.GCPCredentials != "" && c.GCPCredentialsFile != "" {
+		return fmt.Errorf("%w: set either PLUGIN_GCP_CREDENTIALS or PLUGIN_GCP_CREDENTIALS_FILE, not both", ErrInvalidCredentials)
+	}
+
+	if err := c.validateImpersonation(authMode); err != nil {
+		return err
+	}
+
+	if c.SystemInstruction != "" && c.SystemInstructionFile != "" {
+		return ErrSystemInstructionConflict
+	}
+
+	for _, endpoint := range []string{c.APIEndpoint, c.TokenEndpoint, c.IAMEndpoint} {
+		if endpoint == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidEndpoint, endpoint)
+		}
+	}
+
+	if c.Proxy != "" {
+		if u, err := url.Parse(c.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: proxy must be an absolute URL, got %q", ErrInvalidTLSConfig, c.Proxy)
+		}
+	}
+
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("%w: client_cert and client_key must be set together", ErrInvalidTLSConfig)
+	}
+
+	if c.Agent && (c.AgentMaxSteps < 1 || c.AgentTokenBudget < 0) {
+		return fmt.Errorf("%w: agent_max_steps must be at least 1 and agent_token_budget must not be negative", ErrInvalidAgentConfig)
+	}
+
+	if c.RecordDir != "" && c.ReplayDir != "" {
+		return ErrRecordReplayConflict
+	}
+
+	if err := c.validateGenerationConfig(); err != nil {
+		return err
+	}
+
+	if err := c.validateThinkingConfig(); err != nil {
+		return err
+	}
+
+	if _, err := ParseSafetySettings(c.SafetySettings); err != nil {
+		return err
+	}
+
+	switch c.ResponseMimeType {
+	case "", "text/plain", "application/json":
+	default:
+		return fmt.Errorf("%w: unsupported response_mime_type %q", ErrInvalidResponseSchema, c.ResponseMimeType)
+	}
+
+	if c.ResponseSchema != "" && c.ResponseMimeType == "text/plain" {
+		return fmt.Errorf("%w: response_schema requires application/json output", ErrInvalidResponseSchema)
+	}
+
+	if c.RetryMaxAttempts < 0 || c.RetryBaseDelay < 0 || c.RetryMaxDelay < 0 {
+		return fmt.Errorf("%w: attempts and delays must not be negative", ErrInvalidRetryPolicy)
+	}
+
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		return fmt.Errorf("%w: jitter must be between 0.0 and 1.0", ErrInvalidRetryPolicy)
+	}
+
 	return nil
 }
+
+// validateGenerationConfig checks sampling parameters against the API limits
+func (c *Config) validateGenerationConfig() error {
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		return fmt.Errorf("%w: temperature must be between 0.0 and 2.0", ErrInvalidGenerationConfig)
+	}
+
+	if c.TopP != nil && (*c.TopP < 0 || *c.TopP > 1) {
+		return fmt.Errorf("%w: top_p must be between 0.0 and 1.0", ErrInvalidGenerationConfig)
+	}
+
+	if c.TopK != nil && *c.TopK < 1 {
+		return fmt.Errorf("%w: top_k must be at least 1", ErrInvalidGenerationConfig)
+	}
+
+	if c.CandidateCount < 0 || c.CandidateCount > 8 {
+		return fmt.Errorf("%w: candidate_count must be between 1 and 8", ErrInvalidGenerationConfig)
+	}
+
+	// Only the first candidate can be validated or replied to; continuations
+	// resume the first candidate and leave the others as they are
+	if c.CandidateCount > 1 {
+		switch {
+		case c.ResponseSchema != "":
+			return fmt.Errorf("%w: candidate_count above 1 cannot be combined with response_schema", ErrInvalidGenerationConfig)
+		case len(c.FollowUps) > 0:
+			return fmt.Errorf("%w: candidate_count above 1 cannot be combined with follow_ups", ErrInvalidGenerationConfig)
+		case c.Agent:
+			return fmt.Errorf("%w: candidate_count above 1 cannot be combined with agent mode", ErrInvalidGenerationConfig)
+		}
+	}
+
+	if c.MaxOutputTokens < 0 {
+		return fmt.Errorf("%w: max_output_tokens must not be negative", ErrInvalidGenerationConfig)
+	}
+
+	if c.MaxContinuations < 0 {
+		return fmt.Errorf("%w: max_continuations must not be negative", ErrInvalidGenerationConfig)
+	}
+
+	if len(c.StopSequences) > 5 {
+		return fmt.Errorf("%w: at most 5 stop_sequences are allowed", ErrInvalidGenerationConfig)
+	}
+
+	if c.PresencePenalty != nil && (*c.PresencePenalty < -2 || *c.PresencePenalty >= 2) {
+		return fmt.Errorf("%w: presence_penalty must be in [-2.0, 2.0)", ErrInvalidGenerationConfig)
+	}
+
+	if c.FrequencyPenalty != nil && (*c.FrequencyPenalty < -2 || *c.FrequencyPenalty >= 2) {
+		return fmt.Errorf("%w: frequency_penalty must be in [-2.0, 2.0)", ErrInvalidGenerationConfig)
+	}
+
+	return nil
+}
+
+// validateThinkingConfig checks thinking settings against the configured model family
+func (c *Config) validateThinkingConfig() error {
+	if c.ThinkingBudget != nil && c.ThinkingLevel != "" {
+		return fmt.Errorf("%w: thinking_budget and thinking_level cannot be used together", ErrInvalidThinkingConfig)
+	}
+
+	if c.ThinkingBudget != nil && *c.ThinkingBudget < -1 {
+		return fmt.Errorf("%w: thinking_budget must be -1 (dynamic), 0 (off) or a positive token count", ErrInvalidThinkingConfig)
+	}
+
+	if c.ThinkingLevel != "" {
+		switch strings.ToLower(c.ThinkingLevel) {
+		case "minimal", "low", "medium", "high":
+		default:
+			return fmt.Errorf("%w: thinking_level must be one of minimal, low, medium, high", ErrInvalidThinkingConfig)
+		}
+
+		// Every model in the fallback chain must accept the setting
+		for _, model := range c.Models() {
+			if strings.HasPrefix(model, "gemini-2") {
+				return fmt.Errorf("%w: thinking_level is only supported by Gemini 3 models, use thinking_budget for %s", ErrInvalidThinkingConfig, model)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadSystemInstruction returns the inline system instruction or the content of
+// the system instruction file. Returns an empty string when neither is set.
+func (c *Config) LoadSystemInstruction() (string, error) {
+	if c.SystemInstructionFile == "" {
+		return c.SystemInstruction, nil
+	}
+
+	data, err := os.ReadFile(c.SystemInstructionFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read system instruction file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import "context"
+
+// continuationPrompt asks the model to resume an answer cut off at the output token limit
+const continuationPrompt = "Your previous response was cut off by the output token limit. " +
+	"Continue exactly where it stopped, without repeating or summarizing earlier text."
+
+// continueTruncated sends continuation turns while the answer ends with finish
+// reason MAX_TOKENS, up to PLUGIN_MAX_CONTINUATIONS. Each request replays the
+// partial answer as a model turn; the pieces are stitched into one answer and
+// one model turn, and the usage of all calls is summed.
+func (c *GeminiClient) continueTruncated(ctx context.Context, req *GenerateContentRequest, models []string, result *GenerateResult) (*GenerateResult, error) {
+	cfg := c.config
+
+	for n := 1; result.FinishReason == "MAX_TOKENS" && n <= cfg.MaxContinuations; n++ {
+		c.logger.Printf("[WARN] Response truncated at the output token limit, continuing (%d/%d)\n", n, cfg.MaxContinuations)
+
+		contReq := *req
+		contReq.promptTokens = nil
+		contReq.Contents = append(append([]Content{}, req.Contents...),
+			result.Turn,
+			Content{Role: "user", Parts: []Part{{Text: continuationPrompt}}},
+		)
+		// The answer is continued as text, not with further tool calls
+		if len(req.Tools) > 0 {
+			contReq.ToolConfig = &ToolConfig{FunctionCallingConfig: &FunctionCallingConfig{Mode: "NONE"}}
+		}
+		// Only the first candidate is continued
+		if req.GenerationConfig != nil && req.GenerationConfig.CandidateCount > 1 {
+			genCfg := *req.GenerationConfig
+			genCfg.CandidateCount = 1
+			contReq.GenerationConfig = &genCfg
+		}
+
+		next, err := c.generateOnce(ctx, &contReq, modelsFrom(models, result.Usage.ModelID))
+		if next != nil {
+			result.Usage.Add(next.Usage)
+			result.Usage.Continuations++
+		}
+		if err != nil {
+			// The truncated answer is still returned with a cancellation
+			return result, err
+		}
+
+		result.appendToFirstCandidate(next)
+		result.Turn.Parts = append(result.Turn.Parts, next.Turn.Parts...)
+		result.FinishReason = next.FinishReason
+	}
+
+	if result.FinishReason == "MAX_TOKENS" && cfg.MaxContinuations > 0 {
+		c.logger.Printf("[WARN] Response still truncated after %d continuations: increase PLUGIN_MAX_OUTPUT_TOKENS or PLUGIN_MAX_CONTINUATIONS\n", cfg.MaxContinuations)
+	}
+	return result, nil
+}
+
+// appendToFirstCandidate adds the text of a continuation to the first
+// candidate, which is the one the continuation turn resumed
+func (r *GenerateResult) appendToFirstCandidate(next *GenerateResult) {
+	if len(r.answers) == 0 {
+		r.Text += next.Text
+		r.Thoughts += next.Thoughts
+		return
+	}
+	r.answers[0] += next.Text
+	r.thoughts[0] += next.Thoughts
+	r.Text = joinCandidates(r.answers)
+	r.Thoughts = joinCandidates(r.thoughts)
+}
+
+// modelsFrom trims the fallback chain to start at model, keeping later models
+// as fallback. The chain is returned unchanged if it does not contain model.
+func modelsFrom(models []string, model string) []string {
+	for i, m := range models {
+		if m == model {
+			return models[i:]
+		}
+	}
+	return models
+}
@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestGeminiClient_Continuation(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxContinuations int
+		responses        []geminitest.Response
+		wantText         string
+		wantRequests     int
+		wantFinishReason string
+	}{
+		{
+			name:             "continued until stop",
+			maxContinuations: 3,
+			responses: []geminitest.Response{
+				geminitest.FinishResponse("1. SQL injection in ", "MAX_TOKENS"),
+				geminitest.FinishResponse("handler.go\n2. Missing ", "MAX_TOKENS"),
+				geminitest.FinishResponse("CSRF token", "STOP"),
+			},
+			wantText:         "1. SQL injection in handler.go\n2. Missing CSRF token",
+			wantRequests:     3,
+			wantFinishReason: "STOP",
+		},
+		{
+			name:             "limit reached",
+			maxContinuations: 1,
+			responses: []geminitest.Response{
+				geminitest.FinishResponse("1. SQL injection in ", "MAX_TOKENS"),
+				geminitest.FinishResponse("handler.go\n2. Missing ", "MAX_TOKENS"),
+			},
+			wantText:         "1. SQL injection in handler.go\n2. Missing ",
+			wantRequests:     2,
+			wantFinishReason: "MAX_TOKENS",
+		},
+		{
+			name:             "disabled",
+			maxContinuations: 0,
+			responses: []geminitest.Response{
+				geminitest.FinishResponse("1. SQL injection in ", "MAX_TOKENS"),
+			},
+			wantText:         "1. SQL injection in ",
+			wantRequests:     1,
+			wantFinishReason: "MAX_TOKENS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := geminitest.NewServer()
+			defer srv.Close()
+			srv.Enqueue(geminitest.MethodGenerateContent, tt.responses...)
+
+			cfg := execConfig(t)
+			cfg.MaxContinuations = tt.maxContinuations
+
+			result, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).GenerateContent(context.Background())
+			if err != nil {
+				t.Fatalf("GenerateContent() unexpected error: %v", err)
+			}
+			if result.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", result.Text, tt.wantText)
+			}
+			if result.FinishReason != tt.wantFinishReason {
+				t.Errorf("FinishReason = %q, want %q", result.FinishReason, tt.wantFinishReason)
+			}
+			if result.Usage.Continuations != tt.wantRequests-1 {
+				t.Errorf("Continuations = %d, want %d", result.Usage.Continuations, tt.wantRequests-1)
+			}
+
+			requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("generateContent requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			// Output tokens of every piece are summed
+			wantOutput := 0
+			for _, resp := range tt.responses[:tt.wantRequests] {
+				var doc GenerateContentResponse
+				if err := json.Unmarshal([]byte(resp.Body), &doc); err != nil {
+					t.Fatal(err)
+				}
+				wantOutput += doc.UsageMetadata.CandidatesTokenCount
+			}
+			if result.Usage.OutputTokens != wantOutput {
+				t.Errorf("OutputTokens = %d, want %d", result.Usage.OutputTokens, wantOutput)
+			}
+
+			if tt.wantRequests < 2 {
+				return
+			}
+			var last GenerateContentRequest
+			if err := requests[tt.wantRequests-1].JSON(&last); err != nil {
+				t.Fatal(err)
+			}
+			if len(last.Contents) != 3 || last.Contents[1].Role != "model" || last.Contents[2].Parts[0].Text != continuationPrompt {
+				t.Fatalf("continuation contents = %+v, want the partial answer and the continuation prompt", last.Contents)
+			}
+			var partial string
+			for _, part := range last.Contents[1].Parts {
+				partial += part.Text
+			}
+			if want := tt.wantText[:len(partial)]; partial != want {
+				t.Errorf("partial answer = %q, want %q", partial, want)
+			}
+		})
+	}
+}
+
+func TestGeminiClient_ContinuationCandidates(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	candidate := func(text, finishReason string) map[string]any {
+		return map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": finishReason,
+		}
+	}
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.JSONResponse(map[string]any{
+			"candidates":    []any{candidate("1. SQL injection in ", "MAX_TOKENS"), candidate("No issues found.", "STOP")},
+			"usageMetadata": map[string]any{"promptTokenCount": 100, "candidatesTokenCount": 20, "totalTokenCount": 120},
+		}),
+		geminitest.FinishResponse("handler.go", "STOP"),
+	)
+
+	cfg := execConfig(t)
+	cfg.CandidateCount = 2
+	cfg.MaxContinuations = 3
+
+	result, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+	want := "## Candidate 1\n\n1. SQL injection in handler.go\n\n## Candidate 2\n\nNo issues found."
+	if result.Text != want {
+		t.Errorf("Text = %q, want %q", result.Text, want)
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(requests) != 2 {
+		t.Fatalf("generateContent requests = %d, want 2", len(requests))
+	}
+	var continuation GenerateContentRequest
+	if err := requests[1].JSON(&continuation); err != nil {
+		t.Fatal(err)
+	}
+	if continuation.GenerationConfig == nil || continuation.GenerationConfig.CandidateCount != 1 {
+		t.Errorf("continuation generationConfig = %+v, want a single candidate", continuation.GenerationConfig)
+	}
+	var first GenerateContentRequest
+	if err := requests[0].JSON(&first); err != nil {
+		t.Fatal(err)
+	}
+	if first.GenerationConfig == nil || first.GenerationConfig.CandidateCount != 2 {
+		t.Errorf("first generationConfig = %+v, want the configured candidates unchanged", first.GenerationConfig)
+	}
+}
@@ -0,0 +1,339 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials types
+const (
+	CredentialsServiceAccount  = "service_account"
+	CredentialsAuthorizedUser  = "authorized_user"  // gcloud auth application-default login
+	CredentialsExternalAccount = "external_account" // Workload Identity Federation
+	CredentialsMetadata        = "metadata"         // GCE/GKE metadata server, e.g. GKE Workload Identity
+)
+
+// defaultTokenURI is the OAuth token endpoint for credentials files without token_uri
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// Credentials are the Google credentials Vertex AI requests are authorized with
+type Credentials struct {
+	Source    string // where they were found, e.g. PLUGIN_GCP_CREDENTIALS or the metadata server
+	Type      string // one of the Credentials* types
+	ProjectID string // project of the credentials, if known
+	JSON      []byte // credentials file content, empty for the metadata server
+}
+
+// requiredCredentialsFields lists the fields each credentials type cannot work without
+var requiredCredentialsFields = map[string][]string{
+	CredentialsServiceAccount:  {"client_email", "private_key"},
+	CredentialsAuthorizedUser:  {"client_id", "client_secret", "refresh_token"},
+	CredentialsExternalAccount: {"audience", "subject_token_type"},
+}
+
+// parseCredentials validates a credentials file read from source and detects
+// its type and project. The file may be base64-encoded.
+func parseCredentials(source string, data []byte) (*Credentials, error) {
+	data, err := decodeCredentialsJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s: %v", ErrInvalidCredentials, source, err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%w in %s: %v", ErrInvalidCredentials, source, err)
+	}
+	field := func(name string) string {
+		value, _ := fields[name].(string)
+		return value
+	}
+
+	creds := &Credentials{Source: source, Type: field("type"), JSON: data}
+	required, known := requiredCredentialsFields[creds.Type]
+	switch {
+	case creds.Type == "":
+		return nil, fmt.Errorf(`%w in %s: no "type" field, expected %s, %s or %s`, ErrInvalidCredentials, source, CredentialsServiceAccount, CredentialsAuthorizedUser, CredentialsExternalAccount)
+	case !known:
+		return nil, fmt.Errorf("%w in %s: unsupported type %q, expected %s, %s or %s", ErrInvalidCredentials, source, creds.Type, CredentialsServiceAccount, CredentialsAuthorizedUser, CredentialsExternalAccount)
+	}
+	for _, name := range required {
+		if field(name) == "" {
+			return nil, fmt.Errorf("%w in %s: %s credentials need the %q field", ErrInvalidCredentials, source, creds.Type, name)
+		}
+	}
+
+	if creds.Type == CredentialsServiceAccount {
+		// Fail before the first request if the key cannot sign
+		if _, err := parseRSAPrivateKey(field("private_key")); err != nil {
+			return nil, fmt.Errorf("%w in %s: private_key: %v", ErrInvalidCredentials, source, err)
+		}
+		creds.ProjectID = field("project_id")
+	} else {
+		creds.ProjectID = field("quota_project_id")
+	}
+	return creds, nil
+}
+
+// decodeCredentialsJSON returns credentials JSON as is, or decodes it from
+// base64 as some secret managers store it
+func decodeCredentialsJSON(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		return data, nil
+	}
+
+	compact := strings.Join(strings.Fields(string(data)), "")
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(compact); err == nil {
+			if decoded = bytes.TrimSpace(decoded); bytes.HasPrefix(decoded, []byte("{")) {
+				return decoded, nil
+			}
+		}
+	}
+	return nil, errors.New("expected JSON or base64-encoded JSON")
+}
+
+// configuredCredentials returns the credentials given by PLUGIN_GCP_CREDENTIALS
+// or PLUGIN_GCP_CREDENTIALS_FILE, or nil if neither is set
+func configuredCredentials(explicitJSON, file string) (*Credentials, error) {
+	if explicitJSON != "" {
+		return parseCredentials("PLUGIN_GCP_CREDENTIALS", []byte(explicitJSON))
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PLUGIN_GCP_CREDENTIALS_FILE: %w", err)
+		}
+		return parseCredentials(fmt.Sprintf("PLUGIN_GCP_CREDENTIALS_FILE (%s)", file), data)
+	}
+	return nil, nil
+}
+
+// FindDefaultCredentials looks up credentials in Application Default Credentials
+// order: explicit credentials JSON or file, the file named by
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud well-known file, and finally the
+// GCE/GKE metadata server. It returns ErrNoCredentials if none are available.
+func FindDefaultCredentials(ctx context.Context, explicitJSON, file string) (*Credentials, error) {
+	if creds, err := configuredCredentials(explicitJSON, file); creds != nil || err != nil {
+		return creds, err
+	}
+
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		return parseCredentials(fmt.Sprintf("GOOGLE_APPLICATION_CREDENTIALS (%s)", path), data)
+	}
+
+	if path := wellKnownCredentialsFile(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			return parseCredentials(fmt.Sprintf("gcloud application default credentials (%s)", path), data)
+		}
+	}
+
+	metadata := newMetadataServer()
+	if metadata.available(ctx) {
+		// The project is a convenience; PLUGIN_GCP_PROJECT is required without it
+		projectCtx, cancel := context.WithTimeout(ctx, metadataProbeTimeout)
+		project, _ := metadata.projectID(projectCtx)
+		cancel()
+		return &Credentials{
+			Source:    fmt.Sprintf("metadata server (%s)", metadata.host),
+			Type:      CredentialsMetadata,
+			ProjectID: project,
+		}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// wellKnownCredentialsFile returns the path where gcloud auth application-default login
+// stores credentials
+func wellKnownCredentialsFile() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "gcloud", "application_default_credentials.json")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// defaultMetadataHost is the metadata server address on GCE and GKE
+const defaultMetadataHost = "169.254.169.254"
+
+// metadataProbeTimeout bounds the check for a metadata server, which off
+// Google Cloud usually means waiting for a connection that never succeeds
+const metadataProbeTimeout = time.Second
+
+// metadataProbes caches whether a metadata server answered, by host
+var metadataProbes sync.Map
+
+// metadataClient talks to the link-local metadata server directly, never through PLUGIN_PROXY
+var metadataClient = &http.Client{Transport: &http.Transport{}}
+
+// metadataServer is the GCE/GKE metadata server, at GCE_METADATA_HOST if set
+type metadataServer struct {
+	host string
+}
+
+// newMetadataServer returns the metadata server for this environment
+func newMetadataServer() metadataServer {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	return metadataServer{host: host}
+}
+
+// available reports whether the metadata server answers, probing it once per process
+func (m metadataServer) available(ctx context.Context) bool {
+	if ok, probed := metadataProbes.Load(m.host); probed {
+		return ok.(bool)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, metadataProbeTimeout)
+	defer cancel()
+
+	req, err := m.newRequest(ctx, "")
+	if err != nil {
+		return false
+	}
+	resp, err := metadataClient.Do(req)
+	ok := err == nil && resp.Header.Get("Metadata-Flavor") == "Google"
+	if err == nil {
+		resp.Body.Close()
+	}
+	// A probe cut short by the caller says nothing about the server
+	if parent.Err() == nil {
+		metadataProbes.Store(m.host, ok)
+	}
+	return ok
+}
+
+// newRequest builds a request for a computeMetadata/v1 path
+func (m metadataServer) newRequest(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+m.host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return req, nil
+}
+
+// get returns the value of a metadata path
+func (m metadataServer) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := m.newRequest(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("metadata server request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIStatusError(resp, body)
+	}
+	return body, nil
+}
+
+// projectID returns the project the instance or GKE cluster runs in
+func (m metadataServer) projectID(ctx context.Context) (string, error) {
+	body, err := m.get(ctx, "project/project-id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// token returns an access token for the default service account of the
+// instance, or the Kubernetes service account's identity with Workload Identity
+func (m metadataServer) token(ctx context.Context) (*Token, error) {
+	scopes := strings.ReplaceAll(oauthScopes, " ", ",")
+	now := time.Now()
+	body, err := m.get(ctx, "instance/service-accounts/default/token?scopes="+url.QueryEscape(scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	return tokenResp.token(now)
+}
+
+// ResolveCredentials looks up Vertex AI credentials unless an API key is set,
+// and fills in GCPProject from them when it is empty. Finding no credentials
+// is not an error here; Validate reports it.
+func (c *Config) ResolveCredentials(ctx context.Context) error {
+	if c.APIKey != "" {
+		return nil
+	}
+
+	creds, err := FindDefaultCredentials(ctx, c.GCPCredentials, c.GCPCredentialsFile)
+	if errors.Is(err, ErrNoCredentials) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.credentials = creds
+	if c.GCPProject == "" {
+		c.GCPProject = creds.ProjectID
+	}
+	return nil
+}
+
+// gcpCredentials returns the resolved credentials, or the configured ones for
+// configurations that were not resolved
+func (c *Config) gcpCredentials() (*Credentials, error) {
+	if c.credentials != nil {
+		return c.credentials, nil
+	}
+	creds, err := configuredCredentials(c.GCPCredentials, c.GCPCredentialsFile)
+	if creds == nil && err == nil {
+		return nil, ErrNoCredentials
+	}
+	return creds, err
+}
+
+// CredentialsSource describes where the Vertex AI credentials come from
+func (c *Config) CredentialsSource() string {
+	if creds, err := c.gcpCredentials(); err == nil {
+		return creds.Source
+	}
+	return ""
+}
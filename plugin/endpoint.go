@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// defaultAIStudioEndpoint is the base URL for Google AI Studio and the global Vertex AI endpoint
+	defaultAIStudioEndpoint = "https://generativelanguage.googleapis.com"
+
+	// defaultIAMEndpoint is the base URL of the IAM Credentials API
+	defaultIAMEndpoint = "https://iamcredentials.googleapis.com"
+)
+
+// EndpointResolver builds API URLs for the configured authentication mode
+type EndpointResolver struct {
+	config *Config
+}
+
+// NewEndpointResolver creates a new endpoint resolver
+func NewEndpointResolver(cfg *Config) *EndpointResolver {
+	return &EndpointResolver{
+		config: cfg,
+	}
+}
+
+// BaseURL returns the API base URL (scheme and host) for the current auth mode.
+// PLUGIN_API_ENDPOINT takes precedence over the built-in Google endpoints.
+func (r *EndpointResolver) BaseURL() (string, error) {
+	cfg := r.config
+
+	if cfg.APIEndpoint != "" {
+		return strings.TrimRight(cfg.APIEndpoint, "/"), nil
+	}
+
+	switch cfg.DetectAuthMode() {
+	case AuthModeAPIKey:
+		return defaultAIStudioEndpoint, nil
+	case AuthModeVertexAI:
+		if cfg.GCPLocation == "global" {
+			return defaultAIStudioEndpoint, nil
+		}
+		return fmt.Sprintf("https://%s-aiplatform.googleapis.com", cfg.GCPLocation), nil
+	default:
+		return "", ErrNoCredentials
+	}
+}
+
+// ModelURL returns the full URL for calling a model method (e.g. generateContent).
+// For Google AI Studio the API key is included as a query parameter, and
+// streamGenerateContent requests server-sent events with alt=sse.
+func (r *EndpointResolver) ModelURL(model, method string) (string, error) {
+	cfg := r.config
+
+	base, err := r.BaseURL()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if method == "streamGenerateContent" {
+		query.Set("alt", "sse")
+	}
+
+	var modelURL string
+	switch cfg.DetectAuthMode() {
+	case AuthModeAPIKey:
+		// Google AI Studio: {base}/v1beta/models/{model}:{method}?key=...
+		modelURL = fmt.Sprintf("%s/v1beta/models/%s:%s", base, model, method)
+		query.Set("key", cfg.APIKey)
+
+	case AuthModeVertexAI:
+		if cfg.GCPLocation == "global" {
+			// Global: generativelanguage-style path authenticated with OAuth
+			modelURL = fmt.Sprintf("%s/v1beta/models/%s:%s", base, model, method)
+		} else {
+			// Regional: {region}-aiplatform.googleapis.com publisher model path
+			modelURL = fmt.Sprintf(
+				"%s/v1/projects/%s/locations/%s/publishers/google/models/%s:%s",
+				base,
+				cfg.GCPProject,
+				cfg.GCPLocation,
+				model,
+				method,
+			)
+		}
+
+	default:
+		return "", ErrNoCredentials
+	}
+
+	if len(query) > 0 {
+		modelURL += "?" + query.Encode()
+	}
+	return modelURL, nil
+}
+
+// ModelsURL returns the URL of a model resource (models.get), or of the model
+// list (models.list) when model is empty. Regional Vertex AI serves Google
+// publisher models, which can only be listed through the v1beta1 API.
+func (r *EndpointResolver) ModelsURL(model string) (string, error) {
+	cfg := r.config
+
+	base, err := r.BaseURL()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+
+	var modelsURL string
+	switch cfg.DetectAuthMode() {
+	case AuthModeAPIKey:
+		modelsURL = base + "/v1beta/models"
+		query.Set("key", cfg.APIKey)
+
+	case AuthModeVertexAI:
+		switch {
+		case cfg.GCPLocation == "global":
+			modelsURL = base + "/v1beta/models"
+		case model == "":
+			modelsURL = base + "/v1beta1/publishers/google/models"
+		default:
+			modelsURL = base + "/v1/publishers/google/models"
+		}
+
+	default:
+		return "", ErrNoCredentials
+	}
+
+	if model != "" {
+		modelsURL += "/" + url.PathEscape(model)
+	}
+	if len(query) > 0 {
+		modelsURL += "?" + query.Encode()
+	}
+	return modelsURL, nil
+}
+
+// TokenURL returns the OAuth token endpoint, preferring PLUGIN_TOKEN_ENDPOINT
+// over the token_uri found in the service account credentials.
+func (r *EndpointResolver) TokenURL(credentialsTokenURI string) string {
+	if r.config.TokenEndpoint != "" {
+		return r.config.TokenEndpoint
+	}
+	return credentialsTokenURI
+}
+
+// ImpersonationURL returns the IAM Credentials generateAccessToken URL for a
+// service account. PLUGIN_IAM_ENDPOINT takes precedence over the Google endpoint.
+func (r *EndpointResolver) ImpersonationURL(serviceAccount string) string {
+	base := defaultIAMEndpoint
+	if r.config.IAMEndpoint != "" {
+		base = strings.TrimRight(r.config.IAMEndpoint, "/")
+	}
+	return fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:generateAccessToken", base, url.PathEscape(serviceAccount))
+}
+
+// Describe returns a short human-readable description of the endpoint in use
+func (r *EndpointResolver) Describe() string {
+	cfg := r.config
+
+	switch cfg.DetectAuthMode() {
+	case AuthModeAPIKey:
+		return "Google AI Studio endpoint"
+	case AuthModeVertexAI:
+		if cfg.GCPLocation == "global" {
+			return fmt.Sprintf("Vertex AI global endpoint (Project: %s)", cfg.GCPProject)
+		}
+		return fmt.Sprintf("Vertex AI regional endpoint (Project: %s, Location: %s)", cfg.GCPProject, cfg.GCPLocation)
+	default:
+		return "no endpoint"
+	}
+}
@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointResolver_ModelURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{
+			name: "Google AI Studio",
+			config: Config{
+				APIKey: "test-key",
+			},
+			expected: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent?key=test-key",
+		},
+		{
+			name: "Vertex AI regional",
+			config: Config{
+				GCPCredentials: `{"type":"service_account"}`,
+				GCPProject:     "my-project",
+				GCPLocation:    "us-central1",
+			},
+			expected: "https://us-central1-aiplatform.googleapis.com/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.5-pro:generateContent",
+		},
+		{
+			name: "Vertex AI global",
+			config: Config{
+				GCPCredentials: `{"type":"service_account"}`,
+				GCPProject:     "my-project",
+				GCPLocation:    "global",
+			},
+			expected: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent",
+		},
+		{
+			name: "API endpoint override",
+			config: Config{
+				APIKey:      "test-key",
+				APIEndpoint: "http://gateway.internal:8080/",
+			},
+			expected: "http://gateway.internal:8080/v1beta/models/gemini-2.5-pro:generateContent?key=test-key",
+		},
+		{
+			name: "API endpoint override with Vertex AI",
+			config: Config{
+				GCPCredentials: `{"type":"service_account"}`,
+				GCPProject:     "my-project",
+				GCPLocation:    "europe-west4",
+				APIEndpoint:    "http://localhost:9000",
+			},
+			expected: "http://localhost:9000/v1/projects/my-project/locations/europe-west4/publishers/google/models/gemini-2.5-pro:generateContent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEndpointResolver(&tt.config).ModelURL("gemini-2.5-pro", "generateContent")
+			if err != nil {
+				t.Fatalf("ModelURL() unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ModelURL() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEndpointResolver_NoCredentials(t *testing.T) {
+	_, err := NewEndpointResolver(&Config{}).ModelURL("gemini-2.5-pro", "generateContent")
+	if err != ErrNoCredentials {
+		t.Errorf("ModelURL() error = %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestEndpointResolver_TokenURL(t *testing.T) {
+	resolver := NewEndpointResolver(&Config{})
+	if got := resolver.TokenURL("https://oauth2.googleapis.com/token"); got != "https://oauth2.googleapis.com/token" {
+		t.Errorf("TokenURL() = %q, want credentials token_uri", got)
+	}
+
+	resolver = NewEndpointResolver(&Config{TokenEndpoint: "http://localhost:9000/token"})
+	if got := resolver.TokenURL("https://oauth2.googleapis.com/token"); got != "http://localhost:9000/token" {
+		t.Errorf("TokenURL() = %q, want override", got)
+	}
+}
+
+func TestConfig_ValidateEndpoints(t *testing.T) {
+	cfg := Config{
+		Prompt:      "Review this code",
+		APIKey:      "test-key",
+		APIEndpoint: "not-a-url",
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for relative API endpoint")
+	}
+
+	cfg.APIEndpoint = "https://gateway.example.com"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	cfg.IAMEndpoint = "gateway.example.com/iam"
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidEndpoint) {
+		t.Errorf("Validate() error = %v, want ErrInvalidEndpoint for relative IAM endpoint", err)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGeminiClient_WithTransport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotURL string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotURL = req.URL.String()
+		body := `{"candidates":[{"content":{"role":"model","parts":[{"text":"looks good"}]}}],` +
+			`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":2,"totalTokenCount":12}}`
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+
+	cfg := Config{
+		Prompt:      "Review",
+		Target:      dir,
+		Model:       "gemini-2.5-flash",
+		APIKey:      "test-key",
+		APIEndpoint: "http://fake.local",
+		Timeout:     10,
+	}
+
+	result, err := NewGeminiClient(&cfg, WithTransport(transport)).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+	if result.Text != "looks good" {
+		t.Errorf("GenerateContent() text = %q, want %q", result.Text, "looks good")
+	}
+	if result.Usage == nil || result.Usage.InputTokens != 10 {
+		t.Errorf("GenerateContent() usage = %+v, want 10 input tokens", result.Usage)
+	}
+	if !strings.HasPrefix(gotURL, "http://fake.local/v1beta/models/gemini-2.5-flash:generateContent") {
+		t.Errorf("request URL = %q, want fake endpoint", gotURL)
+	}
+}
+
+func TestEndpointResolver_StreamURL(t *testing.T) {
+	cfg := Config{APIKey: "test-key"}
+	got, err := NewEndpointResolver(&cfg).ModelURL("gemini-2.5-pro", "streamGenerateContent")
+	if err != nil {
+		t.Fatalf("ModelURL() unexpected error: %v", err)
+	}
+	expected := "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse&key=test-key"
+	if got != expected {
+		t.Errorf("ModelURL() = %q, want %q", got, expected)
+	}
+}
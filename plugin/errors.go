@@ -1,14 +1,148 @@
 package plugin
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
 
 var (
 	// ErrPromptRequired is returned when no prompt is provided
 	ErrPromptRequired = errors.New("prompt is required: set PLUGIN_PROMPT")
 
 	// ErrNoCredentials is returned when no authentication credentials are provided
-	ErrNoCredentials = errors.New("no credentials provided: set PLUGIN_API_KEY for Google AI Studio or PLUGIN_GCP_CREDENTIALS + PLUGIN_GCP_PROJECT for Vertex AI")
+	ErrNoCredentials = errors.New("no credentials provided: set PLUGIN_API_KEY for Google AI Studio, or PLUGIN_GCP_CREDENTIALS or GOOGLE_APPLICATION_CREDENTIALS for Vertex AI (on GCE/GKE the metadata server is used)")
 
 	// ErrProjectRequired is returned when using Vertex AI without a project ID
-	ErrProjectRequired = errors.New("GCP project ID is required for Vertex AI: set PLUGIN_GCP_PROJECT")
+	ErrProjectRequired = errors.New("GCP project ID is required for Vertex AI: set PLUGIN_GCP_PROJECT, it could not be detected from the credentials")
+
+	// ErrSystemInstructionConflict is returned when both inline and file system instructions are set
+	ErrSystemInstructionConflict = errors.New("set either PLUGIN_SYSTEM_INSTRUCTION or PLUGIN_SYSTEM_INSTRUCTION_FILE, not both")
+
+	// ErrInvalidEndpoint is returned when an endpoint override is not an absolute URL
+	ErrInvalidEndpoint = errors.New("invalid endpoint: PLUGIN_API_ENDPOINT, PLUGIN_TOKEN_ENDPOINT and PLUGIN_IAM_ENDPOINT must be absolute URLs")
+
+	// ErrInvalidGenerationConfig is returned when sampling parameters are out of range
+	ErrInvalidGenerationConfig = errors.New("invalid generation config")
+
+	// ErrInvalidThinkingConfig is returned when thinking settings are invalid for the model
+	ErrInvalidThinkingConfig = errors.New("invalid thinking config")
+
+	// ErrInvalidSafetySettings is returned when PLUGIN_SAFETY_SETTINGS cannot be parsed
+	ErrInvalidSafetySettings = errors.New("invalid safety settings")
+
+	// ErrInvalidResponseSchema is returned when the response schema cannot be loaded
+	ErrInvalidResponseSchema = errors.New("invalid response schema")
+
+	// ErrSchemaValidation is returned when the response does not match the schema after a retry
+	ErrSchemaValidation = errors.New("response does not match the response schema")
+
+	// ErrEmptyResponse is returned when the model returns no text
+	ErrEmptyResponse = errors.New("model returned an empty response")
+
+	// ErrModelNotFound is returned when the models endpoint does not know a configured model
+	ErrModelNotFound = errors.New("model not found")
+
+	// ErrInvalidCredentials is returned when Google credentials cannot be used
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrInvalidImpersonation is returned when service account impersonation settings are invalid
+	ErrInvalidImpersonation = errors.New("invalid service account impersonation")
+
+	// ErrInvalidTLSConfig is returned when proxy, CA bundle or client certificate settings are invalid
+	ErrInvalidTLSConfig = errors.New("invalid proxy/TLS configuration")
+
+	// ErrInvalidAgentConfig is returned when the agent step or token budget is invalid
+	ErrInvalidAgentConfig = errors.New("invalid agent configuration")
+
+	// ErrOutsideWorkspace is returned when a tool call names a path outside the workspace
+	ErrOutsideWorkspace = errors.New("path is outside the workspace")
+
+	// ErrExcludedPath is returned when a tool call names a hidden or excluded path
+	ErrExcludedPath = errors.New("path is excluded from the review context")
+
+	// ErrRecordReplayConflict is returned when both record and replay directories are set
+	ErrRecordReplayConflict = errors.New("record_dir and replay_dir cannot both be set")
+
+	// ErrCassetteNotFound is returned in replay mode when no cassette matches a request
+	ErrCassetteNotFound = errors.New("no recorded response for request")
+
+	// ErrInvalidRetryPolicy is returned when retry settings are out of range
+	ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+	// ErrRetriesExhausted is returned when all retry attempts for an API call failed
+	ErrRetriesExhausted = errors.New("retries exhausted")
 )
+
+// APIStatusError is returned when the API responds with a non-200 status
+type APIStatusError struct {
+	StatusCode int
+	Status     string        // google.rpc status, e.g. RESOURCE_EXHAUSTED
+	Message    string        // error message from the API, if any
+	Body       string        // raw response body
+	RetryDelay time.Duration // server-requested delay from Retry-After or RetryInfo
+}
+
+func (e *APIStatusError) Error() string {
+	if e.Message != "" {
+		if e.Status != "" {
+			return fmt.Sprintf("API returned status %d %s: %s", e.StatusCode, e.Status, e.Message)
+		}
+		return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// statusText returns the HTTP code with the google.rpc status, e.g. "429 RESOURCE_EXHAUSTED"
+func (e *APIStatusError) statusText() string {
+	if e.Status != "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, e.Status)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Retryable reports whether the request may succeed if sent again
+func (e *APIStatusError) Retryable() bool {
+	return isRetryableStatus(e.StatusCode)
+}
+
+// PromptBlockedError is returned when the API refuses the prompt (promptFeedback.blockReason)
+type PromptBlockedError struct {
+	Reason  string // e.g. SAFETY, BLOCKLIST, PROHIBITED_CONTENT
+	Message string
+	Ratings []SafetyRating
+}
+
+func (e *PromptBlockedError) Error() string {
+	msg := fmt.Sprintf("prompt blocked by the API: %s", e.Reason)
+	if e.Message != "" {
+		msg += " (" + e.Message + ")"
+	}
+	if flagged := formatRatings(e.Ratings); flagged != "" {
+		msg += " [" + flagged + "]"
+	}
+	return msg
+}
+
+// CandidateStoppedError is returned when generation stopped without a usable answer,
+// e.g. finish reason SAFETY or RECITATION, or MAX_TOKENS before any text was produced
+type CandidateStoppedError struct {
+	FinishReason string
+	Message      string
+	Ratings      []SafetyRating
+}
+
+func (e *CandidateStoppedError) Error() string {
+	msg := fmt.Sprintf("response stopped with finish reason %s", e.FinishReason)
+	if e.Message != "" {
+		msg += " (" + e.Message + ")"
+	}
+	if flagged := formatRatings(e.Ratings); flagged != "" {
+		msg += " [" + flagged + "]"
+	}
+	if e.FinishReason == "MAX_TOKENS" {
+		msg += ": increase PLUGIN_MAX_OUTPUT_TOKENS or lower the thinking budget"
+	}
+	return msg
+}
@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+// execConfig returns a minimal AI Studio configuration reviewing a small repository
+func execConfig(t *testing.T) Config {
+	t.Helper()
+	return Config{
+		Prompt:           "Review this code",
+		APIKey:           "test-key",
+		Model:            "gemini-2.5-flash",
+		Target:           writeTestFiles(t, map[string]string{"main.go": "package main\n"}),
+		Timeout:          10,
+		RetryMaxAttempts: 3,
+		RetryBaseDelay:   1,
+	}
+}
+
+func TestExec_AIStudio(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	cfg := execConfig(t)
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	requests := srv.Requests()
+	var methods []string
+	for _, req := range requests {
+		methods = append(methods, req.Method)
+	}
+	if strings.Join(methods, ",") != "models.get,countTokens,generateContent" {
+		t.Fatalf("requests = %v, want models.get, countTokens then generateContent", methods)
+	}
+
+	generate := requests[2]
+	if generate.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+		t.Errorf("path = %q, want the AI Studio model path", generate.Path)
+	}
+	if generate.Query.Get("key") != "test-key" {
+		t.Errorf("key = %q, want test-key", generate.Query.Get("key"))
+	}
+
+	var body GenerateContentRequest
+	if err := generate.JSON(&body); err != nil {
+		t.Fatalf("failed to decode request: %v", err)
+	}
+	if !strings.Contains(body.text(), "package main") {
+		t.Error("request does not contain the repository files")
+	}
+}
+
+func TestExec_VertexAI(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	creds, err := geminitest.ServiceAccountJSON("my-project", srv.TokenURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := execConfig(t)
+	cfg.APIKey = ""
+	cfg.GCPCredentials = creds
+	cfg.GCPProject = "my-project"
+	cfg.GCPLocation = "europe-west4"
+	cfg.APIEndpoint = srv.URL
+
+	if err := New(cfg).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	tokenRequests := srv.RequestsFor(geminitest.MethodToken)
+	if len(tokenRequests) != 1 {
+		t.Fatalf("token requests = %d, want one exchange reused by all API calls", len(tokenRequests))
+	}
+	form, _ := tokenRequests[0].Form()
+	if form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || form.Get("assertion") == "" {
+		t.Errorf("token form = %v, want a JWT bearer assertion", form)
+	}
+
+	generate := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(generate) != 1 {
+		t.Fatalf("generateContent requests = %d, want 1", len(generate))
+	}
+	if want := "/v1/projects/my-project/locations/europe-west4/publishers/google/models/gemini-2.5-flash:generateContent"; generate[0].Path != want {
+		t.Errorf("path = %q, want %q", generate[0].Path, want)
+	}
+	if got := generate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.DefaultAccessToken {
+		t.Errorf("Authorization = %q, want the fake access token", got)
+	}
+}
+
+func TestExec_Stream(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodStreamGenerateContent, geminitest.StreamTextResponse(100, "No ", "issues ", "found."))
+
+	cfg := execConfig(t)
+	cfg.Stream = true
+
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	stream := srv.RequestsFor(geminitest.MethodStreamGenerateContent)
+	if len(stream) != 1 || stream[0].Query.Get("alt") != "sse" {
+		t.Errorf("stream requests = %+v, want one request with alt=sse", stream)
+	}
+}
+
+func TestExec_ErrorInjection(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       string
+		responses   []geminitest.Response
+		expectError bool
+		models      []string
+	}{
+		{
+			name:  "Retries transient errors",
+			model: "gemini-2.5-flash",
+			responses: []geminitest.Response{
+				geminitest.ErrorResponse(http.StatusServiceUnavailable, "UNAVAILABLE", "overloaded"),
+				geminitest.ErrorResponse(http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "quota").RetryAfter(0),
+			},
+			models: []string{"gemini-2.5-flash", "gemini-2.5-flash", "gemini-2.5-flash"},
+		},
+		{
+			name:  "Falls back on missing model",
+			model: "gemini-3-pro-preview,gemini-2.5-flash",
+			responses: []geminitest.Response{
+				geminitest.ErrorResponse(http.StatusNotFound, "NOT_FOUND", "model not found"),
+			},
+			models: []string{"gemini-3-pro-preview", "gemini-2.5-flash"},
+		},
+		{
+			name:  "Fails on blocked response",
+			model: "gemini-2.5-flash",
+			responses: []geminitest.Response{
+				geminitest.FinishResponse("", "SAFETY"),
+			},
+			expectError: true,
+			models:      []string{"gemini-2.5-flash"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := geminitest.NewServer()
+			defer srv.Close()
+			srv.Enqueue(geminitest.MethodGenerateContent, tt.responses...)
+
+			cfg := execConfig(t)
+			cfg.Model = tt.model
+
+			err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background())
+			if tt.expectError != (err != nil) {
+				t.Fatalf("Exec() error = %v, expectError %v", err, tt.expectError)
+			}
+
+			var models []string
+			for _, req := range srv.RequestsFor(geminitest.MethodGenerateContent) {
+				models = append(models, req.Model)
+			}
+			if strings.Join(models, ",") != strings.Join(tt.models, ",") {
+				t.Errorf("generateContent models = %v, want %v", models, tt.models)
+			}
+		})
+	}
+}
+
+func TestExec_Cancelled(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent, geminitest.TextResponse("late", 100).WithDelay(5*time.Second))
+
+	// Simulate Drone cancelling the build while the request is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := New(execConfig(t), WithTransport(srv.Transport())).Exec(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Exec() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Exec() took %v after cancellation, want it to stop promptly", elapsed)
+	}
+}
+
+func TestExec_FollowUps(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.TextResponse("Found a nil dereference, a leak and a race.", 1000),
+		geminitest.TextResponse("- [ ] nil dereference", 1100),
+		geminitest.TextResponse("Fix the race first.", 1200),
+	)
+
+	cfg := execConfig(t)
+	cfg.FollowUps = StringList{"Summarize the issues as a checklist, most important first", "Which one should be fixed first?"}
+
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(requests) != 3 {
+		t.Fatalf("generateContent requests = %d, want 3 turns", len(requests))
+	}
+
+	var last GenerateContentRequest
+	if err := requests[2].JSON(&last); err != nil {
+		t.Fatal(err)
+	}
+
+	var roles []string
+	for _, content := range last.Contents {
+		roles = append(roles, content.Role)
+	}
+	if strings.Join(roles, ",") != "user,model,user,model,user" {
+		t.Fatalf("roles = %v, want alternating user and model turns", roles)
+	}
+	if !strings.Contains(last.Contents[0].Parts[len(last.Contents[0].Parts)-1].Text, "package main") {
+		t.Error("follow-up does not reuse the code context of the first turn")
+	}
+	if last.Contents[1].Parts[0].Text != "Found a nil dereference, a leak and a race." {
+		t.Errorf("model turn = %+v, want the first answer", last.Contents[1])
+	}
+	if last.Contents[4].Parts[0].Text != "Which one should be fixed first?" {
+		t.Errorf("last user turn = %+v, want the second follow-up", last.Contents[4])
+	}
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExternalAccountCredentials represents a Workload Identity Federation
+// credential configuration (type external_account), as written by
+// gcloud iam workload-identity-pools create-cred-config
+type ExternalAccountCredentials struct {
+	Type                           string            `json:"type"`
+	Audience                       string            `json:"audience"`
+	SubjectTokenType               string            `json:"subject_token_type"`
+	TokenURL                       string            `json:"token_url"`
+	ServiceAccountImpersonationURL string            `json:"service_account_impersonation_url"`
+	ServiceAccountImpersonation    impersonationOpts `json:"service_account_impersonation"`
+	CredentialSource               credentialSource  `json:"credential_source"`
+	QuotaProjectID                 string            `json:"quota_project_id"`
+}
+
+// impersonationOpts configures the impersonation step of external credentials
+type impersonationOpts struct {
+	TokenLifetimeSeconds int `json:"token_lifetime_seconds"`
+}
+
+// credentialSource says where the subject token of external credentials is read from
+type credentialSource struct {
+	File    string            `json:"file"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  struct {
+		Type                  string `json:"type"` // text (default) or json
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+}
+
+// defaultSTSURL is the Security Token Service endpoint for configs without token_url
+const defaultSTSURL = "https://sts.googleapis.com/v1/token"
+
+// fetchExternalAccountToken exchanges the subject token (e.g. an OIDC token
+// issued to the build) for a federated access token at the Security Token
+// Service, then impersonates the service account if the config names one
+func (c *GeminiClient) fetchExternalAccountToken(ctx context.Context, data []byte) (*Token, error) {
+	var creds ExternalAccountCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse external account credentials: %w", err)
+	}
+	if creds.Audience == "" || creds.SubjectTokenType == "" {
+		return nil, errors.New("external account credentials need audience and subject_token_type")
+	}
+	if creds.TokenURL == "" {
+		creds.TokenURL = defaultSTSURL
+	}
+
+	subjectToken, err := c.subjectToken(ctx, creds.CredentialSource)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tokenResp, err := c.postTokenForm(ctx, "STS token exchange", creds.TokenURL, url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {creds.Audience},
+		"scope":                {oauthScopes},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {creds.SubjectTokenType},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange subject token: %w", err)
+	}
+	federated, err := tokenResp.token(now)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds.ServiceAccountImpersonationURL == "" {
+		return federated, nil
+	}
+	return c.generateAccessToken(ctx, creds.ServiceAccountImpersonationURL, federated, nil, creds.ServiceAccountImpersonation.TokenLifetimeSeconds)
+}
+
+// subjectToken returns PLUGIN_SUBJECT_TOKEN, or reads the token from the credential source
+func (c *GeminiClient) subjectToken(ctx context.Context, source credentialSource) (string, error) {
+	if token := strings.TrimSpace(c.config.SubjectToken); token != "" {
+		return token, nil
+	}
+
+	var data []byte
+	switch {
+	case source.File != "":
+		var err error
+		if data, err = os.ReadFile(source.File); err != nil {
+			return "", fmt.Errorf("failed to read subject token: %w", err)
+		}
+	case source.URL != "":
+		var err error
+		if data, err = c.fetchSubjectToken(ctx, source); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("external account credentials need a subject token: set PLUGIN_SUBJECT_TOKEN or credential_source.file or credential_source.url")
+	}
+
+	if source.Format.Type != "json" {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+		return "", errors.New("subject token is empty")
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("failed to parse subject token JSON: %w", err)
+	}
+	token, _ := fields[source.Format.SubjectTokenFieldName].(string)
+	if token == "" {
+		return "", fmt.Errorf("subject token JSON has no %q field", source.Format.SubjectTokenFieldName)
+	}
+	return token, nil
+}
+
+// fetchSubjectToken gets the subject token from credential_source.url
+func (c *GeminiClient) fetchSubjectToken(ctx context.Context, source credentialSource) ([]byte, error) {
+	resp, err := c.doWithRetry(ctx, "subject token", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", source.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range source.Headers {
+			req.Header.Set(key, value)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subject token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subject token: %w", err)
+	}
+	return data, nil
+}
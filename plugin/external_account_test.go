@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+// externalAccountJSON returns a Workload Identity Federation config exchanging
+// tokens at the fake STS, optionally impersonating a service account
+func externalAccountJSON(t *testing.T, srv *geminitest.Server, source map[string]any, impersonate string) string {
+	t.Helper()
+	creds := map[string]any{
+		"type":               "external_account",
+		"audience":           "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/drone/providers/oidc",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":          srv.STSURL(),
+		"credential_source":  source,
+	}
+	if impersonate != "" {
+		creds["service_account_impersonation_url"] = srv.ImpersonationURL(impersonate)
+		creds["service_account_impersonation"] = map[string]any{"token_lifetime_seconds": 1800}
+	}
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// vertexExecConfig returns an Exec configuration for Vertex AI at the fake server
+func vertexExecConfig(t *testing.T, srv *geminitest.Server, creds string) Config {
+	t.Helper()
+	cfg := execConfig(t)
+	cfg.APIKey = ""
+	cfg.GCPCredentials = creds
+	cfg.GCPProject = "my-project"
+	cfg.APIEndpoint = srv.URL
+	return cfg
+}
+
+func TestExec_ExternalAccount(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "oidc-token")
+	if err := os.WriteFile(tokenFile, []byte("drone-oidc-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds := externalAccountJSON(t, srv, map[string]any{"file": tokenFile}, "reviewer@my-project.iam.gserviceaccount.com")
+
+	if err := New(vertexExecConfig(t, srv, creds)).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	sts := srv.RequestsFor(geminitest.MethodSTS)
+	if len(sts) != 1 {
+		t.Fatalf("STS requests = %d, want 1", len(sts))
+	}
+	form, _ := sts[0].Form()
+	if form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+		form.Get("subject_token") != "drone-oidc-jwt" ||
+		form.Get("subject_token_type") != "urn:ietf:params:oauth:token-type:jwt" ||
+		!strings.Contains(form.Get("audience"), "workloadIdentityPools/drone") {
+		t.Errorf("STS form = %v, want a token exchange of the OIDC token", form)
+	}
+
+	impersonate := srv.RequestsFor(geminitest.MethodGenerateAccessToken)
+	if len(impersonate) != 1 {
+		t.Fatalf("generateAccessToken requests = %d, want 1", len(impersonate))
+	}
+	if got := impersonate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.FederatedAccessToken {
+		t.Errorf("generateAccessToken Authorization = %q, want the federated token", got)
+	}
+	if !strings.Contains(impersonate[0].Path, "reviewer@my-project.iam.gserviceaccount.com") {
+		t.Errorf("generateAccessToken path = %q, want the service account", impersonate[0].Path)
+	}
+	var body generateAccessTokenRequest
+	if err := impersonate[0].JSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Lifetime != "1800s" || len(body.Scope) == 0 {
+		t.Errorf("generateAccessToken body = %+v, want scopes and the configured lifetime", body)
+	}
+
+	generate := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if got := generate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.ImpersonatedAccessToken {
+		t.Errorf("Authorization = %q, want the impersonated token", got)
+	}
+}
+
+func TestExec_ExternalAccountSubjectTokenSetting(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	cfg := vertexExecConfig(t, srv, externalAccountJSON(t, srv, nil, ""))
+	cfg.SubjectToken = "token-from-setting"
+	if err := New(cfg).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	form, _ := srv.RequestsFor(geminitest.MethodSTS)[0].Form()
+	if form.Get("subject_token") != "token-from-setting" {
+		t.Errorf("subject_token = %q, want PLUGIN_SUBJECT_TOKEN", form.Get("subject_token"))
+	}
+	if len(srv.RequestsFor(geminitest.MethodGenerateAccessToken)) != 0 {
+		t.Error("generateAccessToken called without service_account_impersonation_url")
+	}
+	generate := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if got := generate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.FederatedAccessToken {
+		t.Errorf("Authorization = %q, want the federated token", got)
+	}
+}
+
+func TestGeminiClient_SubjectToken(t *testing.T) {
+	dir := t.TempDir()
+	textFile := filepath.Join(dir, "token")
+	jsonFile := filepath.Join(dir, "token.json")
+	os.WriteFile(textFile, []byte("  file-token\n"), 0o600)
+	os.WriteFile(jsonFile, []byte(`{"id_token":"json-token"}`), 0o600)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"value":"url-token"}`))
+	}))
+	defer tokenServer.Close()
+
+	jsonFormat := func(field string) map[string]any {
+		return map[string]any{"type": "json", "subject_token_field_name": field}
+	}
+
+	tests := []struct {
+		name    string
+		source  map[string]any
+		want    string
+		wantErr string
+	}{
+		{name: "text file", source: map[string]any{"file": textFile}, want: "file-token"},
+		{name: "json file", source: map[string]any{"file": jsonFile, "format": jsonFormat("id_token")}, want: "json-token"},
+		{
+			name:   "url",
+			source: map[string]any{"url": tokenServer.URL, "headers": map[string]string{"Authorization": "Bearer request-token"}, "format": jsonFormat("value")},
+			want:   "url-token",
+		},
+		{name: "missing field", source: map[string]any{"file": jsonFile, "format": jsonFormat("access_token")}, wantErr: `no "access_token" field`},
+		{name: "missing file", source: map[string]any{"file": filepath.Join(dir, "missing")}, wantErr: "failed to read subject token"},
+		{name: "no source", source: map[string]any{}, wantErr: "PLUGIN_SUBJECT_TOKEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(tt.source)
+			var source credentialSource
+			if err := json.Unmarshal(data, &source); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &Config{RetryMaxAttempts: 1}
+			got, err := NewGeminiClient(cfg).subjectToken(context.Background(), source)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("subjectToken() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("subjectToken() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("subjectToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
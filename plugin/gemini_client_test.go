@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureTransport records request bodies and answers with a fixed response
+func captureTransport(bodies *[]GenerateContentRequest, response string) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body GenerateContentRequest
+		if req.Body != nil {
+			data, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(data, &body)
+		}
+		*bodies = append(*bodies, body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(response)),
+		}, nil
+	})
+}
+
+// writeTestFiles creates files in a temporary directory and returns its path
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const okResponse = `{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`
+
+func TestGeminiClient_SystemInstruction(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":       "package main\n",
+		"util/util.go":  "package util\n",
+		"reviewer.md":   "You are a strict Go reviewer.\n",
+		"ignored.bin":   "binary",
+		"docs/guide.md": "# Guide\n",
+	})
+
+	var bodies []GenerateContentRequest
+	cfg := Config{
+		Prompt:                "Review",
+		Target:                dir,
+		Model:                 "gemini-2.5-flash",
+		APIKey:                "test-key",
+		APIEndpoint:           "http://fake.local",
+		SystemInstructionFile: filepath.Join(dir, "reviewer.md"),
+	}
+
+	if _, err := NewGeminiClient(&cfg, WithTransport(captureTransport(&bodies, okResponse))).GenerateContent(context.Background()); err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	req := bodies[0]
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "You are a strict Go reviewer." {
+		t.Errorf("systemInstruction = %+v, want content of reviewer.md", req.SystemInstruction)
+	}
+
+	parts := req.Contents[0].Parts
+	if parts[0].Text != "Review" {
+		t.Errorf("first part = %q, want the prompt only", parts[0].Text)
+	}
+
+	var files int
+	for _, part := range parts {
+		if strings.HasPrefix(part.Text, "--- File: ") {
+			files++
+		}
+	}
+	// main.go, util/util.go, reviewer.md and docs/guide.md
+	if files != 4 {
+		t.Errorf("file parts = %d, want 4", files)
+	}
+}
+
+func TestConfig_SystemInstructionConflict(t *testing.T) {
+	cfg := Config{
+		Prompt:                "Review",
+		APIKey:                "test-key",
+		SystemInstruction:     "Be concise",
+		SystemInstructionFile: "reviewer.md",
+	}
+	if err := cfg.Validate(); err != ErrSystemInstructionConflict {
+		t.Errorf("Validate() error = %v, want %v", err, ErrSystemInstructionConflict)
+	}
+}
+
+func TestGeminiClient_BuildContextCancelled(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"main.go": "package main\n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := Config{Target: dir}
+	if _, _, err := NewGeminiClient(&cfg).buildContext(ctx, dir); !errors.Is(err, context.Canceled) {
+		t.Errorf("buildContext() error = %v, want context.Canceled", err)
+	}
+
+	git := NewGitAnalyzer(dir, false)
+	if _, err := git.runGitCommand(ctx, "rev-parse", "--git-dir"); !errors.Is(err, context.Canceled) {
+		t.Errorf("runGitCommand() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestGeminiClient_ModelFallback(t *testing.T) {
+	// gemini-3-pro-preview is not served, gemini-2.5-pro is out of quota
+	var models []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		model := strings.TrimPrefix(req.URL.Path, "/v1beta/models/")
+		model = strings.TrimSuffix(model, ":generateContent")
+		models = append(models, model)
+
+		status, body := http.StatusOK, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}],`+
+			`"usageMetadata":{"promptTokenCount":1000000,"candidatesTokenCount":0}}`
+		switch model {
+		case "gemini-3-pro-preview":
+			status, body = http.StatusNotFound, `{"error":{"code":404,"status":"NOT_FOUND","message":"not found"}}`
+		case "gemini-2.5-pro":
+			status, body = http.StatusTooManyRequests, `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","message":"quota"}}`
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	cfg := Config{
+		Prompt:           "Review",
+		APIKey:           "test-key",
+		Model:            "gemini-3-pro-preview, gemini-2.5-pro, gemini-2.5-flash",
+		Target:           writeTestFiles(t, map[string]string{"main.go": "package main"}),
+		RetryMaxAttempts: 2,
+		RetryBaseDelay:   1,
+	}
+
+	result, err := NewGeminiClient(&cfg, WithTransport(transport)).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	expected := []string{"gemini-3-pro-preview", "gemini-2.5-pro", "gemini-2.5-pro", "gemini-2.5-flash"}
+	if strings.Join(models, ",") != strings.Join(expected, ",") {
+		t.Errorf("requested models = %v, want %v", models, expected)
+	}
+
+	if result.Usage.ModelID != "gemini-2.5-flash" {
+		t.Errorf("Usage.ModelID = %q, want gemini-2.5-flash", result.Usage.ModelID)
+	}
+	if result.Usage.InputCost != 0.30 {
+		t.Errorf("InputCost = %v, want Gemini 2.5 Flash pricing (0.30)", result.Usage.InputCost)
+	}
+	if len(result.Usage.SkippedModels) != 2 {
+		t.Fatalf("SkippedModels = %v, want 2 entries", result.Usage.SkippedModels)
+	}
+	if !strings.Contains(result.Usage.FormatCostSummary(), "gemini-2.5-pro returned 429 RESOURCE_EXHAUSTED") {
+		t.Errorf("cost summary does not explain skipped model:\n%s", result.Usage.FormatCostSummary())
+	}
+}
+
+func TestGeminiClient_ModelFallbackPermanentError(t *testing.T) {
+	var calls int
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"code":400,"message":"bad request"}}`)),
+		}, nil
+	})
+
+	cfg := Config{
+		Prompt: "Review",
+		APIKey: "test-key",
+		Model:  "gemini-3-pro-preview,gemini-2.5-pro",
+		Target: writeTestFiles(t, map[string]string{"main.go": "package main"}),
+	}
+
+	_, err := NewGeminiClient(&cfg, WithTransport(transport)).GenerateContent(context.Background())
+	var apiErr *APIStatusError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("GenerateContent() error = %v, want the 400 from the first model", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 (invalid requests do not fall back)", calls)
+	}
+}
@@ -0,0 +1,37 @@
+package geminitest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// ServiceAccountJSON returns service account credentials with a freshly
+// generated RSA key, exchanging tokens at tokenURI (e.g. Server.TokenURL())
+func ServiceAccountJSON(projectID, tokenURI string) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", fmt.Errorf("geminitest: failed to generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("geminitest: failed to marshal key: %w", err)
+	}
+
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     projectID,
+		"private_key_id": "geminitest",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   fmt.Sprintf("reviewer@%s.iam.gserviceaccount.com", projectID),
+		"token_uri":      tokenURI,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(creds), nil
+}
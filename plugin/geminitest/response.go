@@ -0,0 +1,140 @@
+package geminitest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Response is a scripted response of the fake server
+type Response struct {
+	Status int           // HTTP status, defaults to 200
+	Header http.Header   // extra response headers, e.g. Retry-After
+	Body   string        // raw response body
+	Chunks []string      // server-sent event payloads, sent instead of Body when set
+	Delay  time.Duration // extra latency before responding
+}
+
+// TextResponse returns a generateContent response with a single text answer
+func TextResponse(text string, promptTokens int) Response {
+	return JSONResponse(generateResponse(text, "STOP", promptTokens))
+}
+
+// StreamTextResponse returns a streamGenerateContent response sending each
+// chunk of text as its own event; the last event carries the finish reason and usage
+func StreamTextResponse(promptTokens int, chunks ...string) Response {
+	resp := Response{}
+	for i, chunk := range chunks {
+		event := map[string]any{
+			"candidates": []any{map[string]any{
+				"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": chunk}}},
+			}},
+		}
+		if i == len(chunks)-1 {
+			event = generateResponse(chunk, "STOP", promptTokens)
+		}
+		resp.Chunks = append(resp.Chunks, compactJSON(event))
+	}
+	return resp
+}
+
+// FunctionCallResponse returns a generateContent response in which the model
+// calls a function, e.g. FunctionCallResponse("read_file", map[string]any{"path": "main.go"})
+func FunctionCallResponse(name string, args map[string]any) Response {
+	return JSONResponse(map[string]any{
+		"candidates": []any{map[string]any{
+			"content": map[string]any{"role": "model", "parts": []any{
+				map[string]any{"functionCall": map[string]any{"name": name, "args": args}},
+			}},
+			"finishReason": "STOP",
+		}},
+		"usageMetadata": map[string]any{"promptTokenCount": 100, "candidatesTokenCount": 10, "totalTokenCount": 110},
+	})
+}
+
+// FinishResponse returns a generateContent response stopped for the given
+// finish reason, e.g. SAFETY or MAX_TOKENS
+func FinishResponse(text, finishReason string) Response {
+	return JSONResponse(generateResponse(text, finishReason, 0))
+}
+
+// ErrorResponse returns a Google API error, e.g. 429 RESOURCE_EXHAUSTED
+func ErrorResponse(code int, status, message string) Response {
+	resp := JSONResponse(map[string]any{
+		"error": map[string]any{"code": code, "status": status, "message": message},
+	})
+	resp.Status = code
+	return resp
+}
+
+// RetryAfter sets the Retry-After header of the response
+func (r Response) RetryAfter(d time.Duration) Response {
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set("Retry-After", fmt.Sprintf("%d", int(d.Seconds())))
+	return r
+}
+
+// WithDelay adds latency to the response
+func (r Response) WithDelay(d time.Duration) Response {
+	r.Delay = d
+	return r
+}
+
+// JSONResponse returns a 200 response with v encoded as JSON
+func JSONResponse(v any) Response {
+	return Response{Body: compactJSON(v)}
+}
+
+// generateResponse builds a GenerateContentResponse document
+func generateResponse(text, finishReason string, promptTokens int) map[string]any {
+	var parts []any
+	if text != "" {
+		parts = append(parts, map[string]any{"text": text})
+	}
+	outputTokens := len(text)/4 + 1
+	return map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": parts},
+			"finishReason": finishReason,
+		}},
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     promptTokens,
+			"candidatesTokenCount": outputTokens,
+			"totalTokenCount":      promptTokens + outputTokens,
+		},
+	}
+}
+
+// write sends the response, as server-sent events when it has chunks
+func (r Response) write(w http.ResponseWriter, req *http.Request) {
+	for key, values := range r.Header {
+		w.Header()[key] = values
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if len(r.Chunks) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(r.Body))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+	for _, chunk := range r.Chunks {
+		if req.Context().Err() != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
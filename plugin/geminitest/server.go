@@ -0,0 +1,411 @@
+// Package geminitest provides an in-process fake of the Gemini API for tests.
+//
+// The fake serves generateContent, streamGenerateContent, countTokens and the
+// models.get/models.list lookups for both the Google AI Studio and Vertex AI
+// URL layouts, plus an OAuth token endpoint, a GCE metadata server, the
+// Security Token Service and IAM Credentials generateAccessToken, so
+// Plugin.Exec can be exercised end-to-end without network access.
+// Responses can be scripted per method, errors injected, latency added, and
+// every request is captured for assertions.
+package geminitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API methods served by the fake
+const (
+	MethodGenerateContent       = "generateContent"
+	MethodStreamGenerateContent = "streamGenerateContent"
+	MethodCountTokens           = "countTokens"
+	MethodGetModel              = "models.get"
+	MethodListModels            = "models.list"
+	MethodToken                 = "token"
+	MethodMetadata              = "metadata" // any GCE metadata server path
+	MethodSTS                   = "sts"      // Security Token Service token exchange
+	MethodGenerateAccessToken   = "generateAccessToken"
+)
+
+// FederatedAccessToken is the access token issued by the fake Security Token Service
+const FederatedAccessToken = "geminitest-federated-token"
+
+// ImpersonatedAccessToken is the access token issued by the fake generateAccessToken
+const ImpersonatedAccessToken = "geminitest-impersonated-token"
+
+// DefaultProject is the project ID reported by the fake metadata server
+const DefaultProject = "geminitest-project"
+
+// DefaultAccessToken is the access token issued by the fake token endpoint
+const DefaultAccessToken = "geminitest-access-token"
+
+// Request is a captured request to the fake server
+type Request struct {
+	Method string      // API method, e.g. generateContent or token
+	Model  string      // model ID from the URL, empty for the token endpoint
+	Path   string      // URL path
+	Query  url.Values  // URL query parameters
+	Header http.Header // request headers
+	Body   []byte      // raw request body
+}
+
+// JSON decodes the request body into v
+func (r Request) JSON(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Form parses a form-encoded request body (token exchange)
+func (r Request) Form() (url.Values, error) {
+	return url.ParseQuery(string(r.Body))
+}
+
+// Server is a fake Gemini API server
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	requests    []Request
+	scripts     map[string][]Response
+	latency     time.Duration
+	text        string
+	promptCount int
+	models      []string
+	project     string
+}
+
+// NewServer starts a fake Gemini API server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		scripts:     make(map[string][]Response),
+		text:        "ok",
+		promptCount: 100,
+		project:     DefaultProject,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// TokenURL returns the URL of the fake OAuth token endpoint
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// STSURL returns the URL of the fake Security Token Service, for token_url
+// of external_account credentials
+func (s *Server) STSURL() string {
+	return s.URL + "/v1/token"
+}
+
+// ImpersonationURL returns the fake generateAccessToken URL for a service account
+func (s *Server) ImpersonationURL(email string) string {
+	return s.URL + "/v1/projects/-/serviceAccounts/" + email + ":generateAccessToken"
+}
+
+// MetadataHost returns the host:port of the fake metadata server, for GCE_METADATA_HOST
+func (s *Server) MetadataHost() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Transport returns a RoundTripper that sends every request to the fake server,
+// whatever its host, so default Google endpoints can be used unchanged
+func (s *Server) Transport() http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.Host = target.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})
+}
+
+// SetText sets the answer text of default generate responses
+func (s *Server) SetText(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text = text
+}
+
+// SetPromptTokens sets the prompt token count reported by countTokens and usage metadata
+func (s *Server) SetPromptTokens(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promptCount = count
+}
+
+// SetModels restricts the models known to models.get and models.list.
+// By default every model exists.
+func (s *Server) SetModels(models ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+// SetProject sets the project ID reported by the metadata server
+func (s *Server) SetProject(project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.project = project
+}
+
+// SetLatency delays every response by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Enqueue scripts the next responses for an API method, in order.
+// Once the script is used up the method returns its default response.
+func (s *Server) Enqueue(method string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[method] = append(s.scripts[method], responses...)
+}
+
+// Requests returns all captured requests in arrival order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsFor returns the captured requests for an API method
+func (s *Server) RequestsFor(method string) []Request {
+	var matched []Request
+	for _, req := range s.Requests() {
+		if req.Method == method {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// handle records the request and writes the scripted or default response
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	method, model := route(r.URL.Path)
+
+	req := Request{
+		Method: method,
+		Model:  model,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	resp, scripted := s.next(method)
+	if !scripted {
+		resp = s.defaultResponse(req)
+	}
+	latency := s.latency
+	s.mu.Unlock()
+
+	if !sleep(r, latency+resp.Delay) {
+		return
+	}
+	resp.write(w, r)
+}
+
+// next pops the next scripted response for method; the caller holds s.mu
+func (s *Server) next(method string) (Response, bool) {
+	queue := s.scripts[method]
+	if len(queue) == 0 {
+		return Response{}, false
+	}
+	s.scripts[method] = queue[1:]
+	return queue[0], true
+}
+
+// defaultResponse returns the unscripted answer to req; the caller holds s.mu
+func (s *Server) defaultResponse(req Request) Response {
+	model, body := req.Model, req.Body
+	switch req.Method {
+	case MethodGenerateContent:
+		return TextResponse(s.text, s.promptCount)
+	case MethodStreamGenerateContent:
+		return StreamTextResponse(s.promptCount, splitText(s.text)...)
+	case MethodCountTokens:
+		return JSONResponse(map[string]int{"totalTokens": s.promptCount})
+	case MethodGetModel:
+		if s.models != nil && !contains(s.models, model) {
+			return ErrorResponse(http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("models/%s is not found", model))
+		}
+		return JSONResponse(modelResource(model))
+	case MethodListModels:
+		models := s.models
+		if models == nil {
+			models = []string{"gemini-2.5-pro", "gemini-2.5-flash"}
+		}
+		var list []any
+		for _, model := range models {
+			list = append(list, modelResource(model))
+		}
+		return JSONResponse(map[string]any{"models": list})
+	case MethodToken:
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("grant_type") == "" || (form.Get("assertion") == "" && form.Get("refresh_token") == "") {
+			return ErrorResponse(http.StatusBadRequest, "INVALID_ARGUMENT", "missing grant_type or assertion")
+		}
+		return JSONResponse(map[string]any{
+			"access_token": DefaultAccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	case MethodMetadata:
+		return s.metadataResponse(req)
+	case MethodSTS:
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" || form.Get("subject_token") == "" || form.Get("audience") == "" {
+			resp := JSONResponse(map[string]any{"error": "invalid_request", "error_description": "missing grant_type, subject_token or audience"})
+			resp.Status = http.StatusBadRequest
+			return resp
+		}
+		return JSONResponse(map[string]any{
+			"access_token":      FederatedAccessToken,
+			"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+	case MethodGenerateAccessToken:
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+			return ErrorResponse(http.StatusUnauthorized, "UNAUTHENTICATED", "missing bearer token")
+		}
+		return JSONResponse(map[string]any{
+			"accessToken": ImpersonatedAccessToken,
+			"expireTime":  time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	}
+	return ErrorResponse(http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("unknown method %q", req.Method))
+}
+
+// metadataResponse answers the metadata paths used for Application Default
+// Credentials; the caller holds s.mu
+func (s *Server) metadataResponse(req Request) Response {
+	if req.Header.Get("Metadata-Flavor") != "Google" {
+		return Response{Status: http.StatusForbidden, Body: "missing Metadata-Flavor header"}
+	}
+
+	var resp Response
+	switch strings.TrimPrefix(req.Path, "/computeMetadata/v1/") {
+	case "":
+		resp = Response{}
+	case "project/project-id":
+		resp = Response{Body: s.project}
+	case "instance/service-accounts/default/token":
+		resp = JSONResponse(map[string]any{
+			"access_token": DefaultAccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	default:
+		resp = Response{Status: http.StatusNotFound, Body: "not found"}
+	}
+	resp.Header = http.Header{"Metadata-Flavor": {"Google"}}
+	return resp
+}
+
+// route extracts the API method and model from a request path, e.g.
+// /v1beta/models/gemini-2.5-pro:generateContent,
+// /v1/projects/p/locations/l/publishers/google/models/gemini-2.5-pro:countTokens
+// or /v1beta/models/gemini-2.5-pro (models.get)
+func route(path string) (method, model string) {
+	if path == "/token" {
+		return MethodToken, ""
+	}
+	if strings.HasPrefix(path, "/computeMetadata/") {
+		return MethodMetadata, ""
+	}
+	if path == "/v1/token" {
+		return MethodSTS, ""
+	}
+	if strings.HasSuffix(path, ":generateAccessToken") {
+		return MethodGenerateAccessToken, ""
+	}
+	if strings.HasSuffix(path, "/models") {
+		return MethodListModels, ""
+	}
+
+	idx := strings.LastIndex(path, "/models/")
+	if idx < 0 {
+		return "", ""
+	}
+	model, method, found := strings.Cut(path[idx+len("/models/"):], ":")
+	if !found {
+		return MethodGetModel, model
+	}
+	return method, model
+}
+
+// modelResource builds a models.get document with Gemini 2.5 token limits
+func modelResource(model string) map[string]any {
+	return map[string]any{
+		"name":                       "models/" + model,
+		"displayName":                model,
+		"inputTokenLimit":            1048576,
+		"outputTokenLimit":           65536,
+		"supportedGenerationMethods": []string{"generateContent", "countTokens"},
+	}
+}
+
+// contains reports whether list contains value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for d, returning false if the client went away first
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// splitText splits text in two chunks to exercise stream aggregation
+func splitText(text string) []string {
+	if len(text) < 2 {
+		return []string{text}
+	}
+	return []string{text[:len(text)/2], text[len(text)/2:]}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// compactJSON marshals v, panicking on error since inputs are test fixtures
+func compactJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		panic(fmt.Sprintf("geminitest: %v", err))
+	}
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,75 @@
+package geminitest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		path   string
+		method string
+		model  string
+	}{
+		{"/v1beta/models/gemini-2.5-pro:generateContent", MethodGenerateContent, "gemini-2.5-pro"},
+		{"/v1/projects/p/locations/us-central1/publishers/google/models/gemini-2.5-flash:countTokens", MethodCountTokens, "gemini-2.5-flash"},
+		{"/token", MethodToken, ""},
+		{"/computeMetadata/v1/project/project-id", MethodMetadata, ""},
+		{"/v1/token", MethodSTS, ""},
+		{"/v1/projects/-/serviceAccounts/sa@p.iam.gserviceaccount.com:generateAccessToken", MethodGenerateAccessToken, ""},
+		{"/unknown", "", ""},
+	}
+
+	for _, tt := range tests {
+		method, model := route(tt.path)
+		if method != tt.method || model != tt.model {
+			t.Errorf("route(%q) = (%q, %q), want (%q, %q)", tt.path, method, model, tt.method, tt.model)
+		}
+	}
+}
+
+func TestServer_ScriptedResponses(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetText("default answer")
+	srv.Enqueue(MethodGenerateContent, ErrorResponse(http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "quota"))
+
+	client := &http.Client{Transport: srv.Transport()}
+	post := func() (int, string) {
+		resp, err := client.Post("https://generativelanguage.googleapis.com/v1beta/models/m:generateContent",
+			"application/json", strings.NewReader(`{"contents":[]}`))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := post(); status != http.StatusTooManyRequests {
+		t.Errorf("first status = %d, want scripted 429", status)
+	}
+
+	status, body := post()
+	if status != http.StatusOK {
+		t.Fatalf("second status = %d, want default 200", status)
+	}
+	var resp struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct{ Text string }
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Candidates[0].Content.Parts[0].Text != "default answer" {
+		t.Errorf("default response = %s, want the configured text", body)
+	}
+
+	requests := srv.RequestsFor(MethodGenerateContent)
+	if len(requests) != 2 || requests[0].Model != "m" || string(requests[0].Body) != `{"contents":[]}` {
+		t.Errorf("captured requests = %+v, want two generateContent requests for model m", requests)
+	}
+}
@@ -2,6 +2,7 @@ package plugin
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -21,6 +22,7 @@ type CommitInfo struct {
 type GitAnalyzer struct {
 	repoPath string
 	debug    bool
+	logger   Logger
 }
 
 // NewGitAnalyzer creates a new git analyzer
@@ -28,11 +30,12 @@ func NewGitAnalyzer(repoPath string, debug bool) *GitAnalyzer {
 	return &GitAnalyzer{
 		repoPath: repoPath,
 		debug:    debug,
+		logger:   defaultLogger,
 	}
 }
 
 // DetectCommitSHA detects the commit SHA from environment or git
-func (g *GitAnalyzer) DetectCommitSHA(configSHA string) string {
+func (g *GitAnalyzer) DetectCommitSHA(ctx context.Context, configSHA string) string {
 	// Priority 1: Explicit configuration
 	if configSHA != "" {
 		return configSHA
@@ -50,17 +53,17 @@ func (g *GitAnalyzer) DetectCommitSHA(configSHA string) string {
 	for _, envVar := range droneEnvVars {
 		if sha := os.Getenv(envVar); sha != "" {
 			if g.debug {
-				fmt.Printf("[DEBUG] Detected commit SHA from %s: %s\n", envVar, sha)
+				g.logger.Printf("[DEBUG] Detected commit SHA from %s: %s\n", envVar, sha)
 			}
 			return sha
 		}
 	}
 
 	// Priority 3: Get HEAD from git
-	sha, err := g.runGitCommand("rev-parse", "HEAD")
+	sha, err := g.runGitCommand(ctx, "rev-parse", "HEAD")
 	if err == nil && sha != "" {
 		if g.debug {
-			fmt.Printf("[DEBUG] Detected commit SHA from git HEAD: %s\n", sha)
+			g.logger.Printf("[DEBUG] Detected commit SHA from git HEAD: %s\n", sha)
 		}
 		return strings.TrimSpace(sha)
 	}
@@ -69,14 +72,14 @@ func (g *GitAnalyzer) DetectCommitSHA(configSHA string) string {
 }
 
 // GetCommitInfo retrieves information about a commit
-func (g *GitAnalyzer) GetCommitInfo(sha string) (*CommitInfo, error) {
+func (g *GitAnalyzer) GetCommitInfo(ctx context.Context, sha string) (*CommitInfo, error) {
 	if sha == "" {
 		sha = "HEAD"
 	}
 
 	// Get commit info in a single command
 	format := "%H%n%an%n%ae%n%s%n%ci"
-	output, err := g.runGitCommand("log", "-1", "--format="+format, sha)
+	output, err := g.runGitCommand(ctx, "log", "-1", "--format="+format, sha)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commit info: %w", err)
 	}
@@ -96,13 +99,13 @@ func (g *GitAnalyzer) GetCommitInfo(sha string) (*CommitInfo, error) {
 }
 
 // GetChangedFiles returns list of files changed in a commit
-func (g *GitAnalyzer) GetChangedFiles(sha string) ([]string, error) {
+func (g *GitAnalyzer) GetChangedFiles(ctx context.Context, sha string) ([]string, error) {
 	if sha == "" {
 		sha = "HEAD"
 	}
 
 	// Get list of changed files
-	output, err := g.runGitCommand("diff-tree", "--no-commit-id", "--name-only", "-r", sha)
+	output, err := g.runGitCommand(ctx, "diff-tree", "--no-commit-id", "--name-only", "-r", sha)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get changed files: %w", err)
 	}
@@ -120,16 +123,16 @@ func (g *GitAnalyzer) GetChangedFiles(sha string) ([]string, error) {
 }
 
 // GetCommitDiff returns the diff of a commit
-func (g *GitAnalyzer) GetCommitDiff(sha string) (string, error) {
+func (g *GitAnalyzer) GetCommitDiff(ctx context.Context, sha string) (string, error) {
 	if sha == "" {
 		sha = "HEAD"
 	}
 
 	// Get the diff with some context
-	output, err := g.runGitCommand("diff", sha+"^.."+sha, "--unified=3")
+	output, err := g.runGitCommand(ctx, "diff", sha+"^.."+sha, "--unified=3")
 	if err != nil {
 		// Try without parent (for initial commit)
-		output, err = g.runGitCommand("show", sha, "--format=", "--unified=3")
+		output, err = g.runGitCommand(ctx, "show", sha, "--format=", "--unified=3")
 		if err != nil {
 			return "", fmt.Errorf("failed to get commit diff: %w", err)
 		}
@@ -139,14 +142,14 @@ func (g *GitAnalyzer) GetCommitDiff(sha string) (string, error) {
 }
 
 // GetDiffStats returns a summary of changes in a commit
-func (g *GitAnalyzer) GetDiffStats(sha string) (string, error) {
+func (g *GitAnalyzer) GetDiffStats(ctx context.Context, sha string) (string, error) {
 	if sha == "" {
 		sha = "HEAD"
 	}
 
-	output, err := g.runGitCommand("diff", sha+"^.."+sha, "--stat")
+	output, err := g.runGitCommand(ctx, "diff", sha+"^.."+sha, "--stat")
 	if err != nil {
-		output, err = g.runGitCommand("show", sha, "--format=", "--stat")
+		output, err = g.runGitCommand(ctx, "show", sha, "--format=", "--stat")
 		if err != nil {
 			return "", fmt.Errorf("failed to get diff stats: %w", err)
 		}
@@ -156,17 +159,17 @@ func (g *GitAnalyzer) GetDiffStats(sha string) (string, error) {
 }
 
 // IsGitRepository checks if the path is a git repository
-func (g *GitAnalyzer) IsGitRepository() bool {
-	_, err := g.runGitCommand("rev-parse", "--git-dir")
+func (g *GitAnalyzer) IsGitRepository(ctx context.Context) bool {
+	_, err := g.runGitCommand(ctx, "rev-parse", "--git-dir")
 	return err == nil
 }
 
 // BuildGitContext builds a context string with git information
-func (g *GitAnalyzer) BuildGitContext(sha string) (string, error) {
+func (g *GitAnalyzer) BuildGitContext(ctx context.Context, sha string) (string, error) {
 	var context strings.Builder
 
 	// Get commit info
-	commitInfo, err := g.GetCommitInfo(sha)
+	commitInfo, err := g.GetCommitInfo(ctx, sha)
 	if err != nil {
 		return "", err
 	}
@@ -179,7 +182,7 @@ func (g *GitAnalyzer) BuildGitContext(sha string) (string, error) {
 	context.WriteString("\n")
 
 	// Get changed files
-	changedFiles, err := g.GetChangedFiles(sha)
+	changedFiles, err := g.GetChangedFiles(ctx, sha)
 	if err == nil && len(changedFiles) > 0 {
 		context.WriteString("=== Changed Files ===\n")
 		for _, f := range changedFiles {
@@ -189,7 +192,7 @@ func (g *GitAnalyzer) BuildGitContext(sha string) (string, error) {
 	}
 
 	// Get diff stats
-	stats, err := g.GetDiffStats(sha)
+	stats, err := g.GetDiffStats(ctx, sha)
 	if err == nil && stats != "" {
 		context.WriteString("=== Change Statistics ===\n")
 		context.WriteString(stats)
@@ -197,7 +200,7 @@ func (g *GitAnalyzer) BuildGitContext(sha string) (string, error) {
 	}
 
 	// Get actual diff (truncate if too long)
-	diff, err := g.GetCommitDiff(sha)
+	diff, err := g.GetCommitDiff(ctx, sha)
 	if err == nil && diff != "" {
 		context.WriteString("=== Commit Diff ===\n")
 		// Limit diff size to ~50KB to leave room for code context
@@ -214,8 +217,8 @@ func (g *GitAnalyzer) BuildGitContext(sha string) (string, error) {
 }
 
 // runGitCommand executes a git command and returns the output
-func (g *GitAnalyzer) runGitCommand(args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
+func (g *GitAnalyzer) runGitCommand(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = g.repoPath
 
 	var stdout, stderr bytes.Buffer
@@ -224,6 +227,9 @@ func (g *GitAnalyzer) runGitCommand(args ...string) (string, error) {
 
 	err := cmd.Run()
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", fmt.Errorf("git %s failed: %s", strings.Join(args, " "), stderr.String())
 	}
 
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// validateImpersonation checks PLUGIN_IMPERSONATE_SERVICE_ACCOUNT and its delegates
+func (c *Config) validateImpersonation(authMode AuthMode) error {
+	if c.ImpersonateServiceAccount == "" {
+		if len(c.ImpersonateDelegates) > 0 {
+			return fmt.Errorf("%w: impersonate_delegates needs impersonate_service_account", ErrInvalidImpersonation)
+		}
+		return nil
+	}
+	if authMode != AuthModeVertexAI {
+		return fmt.Errorf("%w: impersonate_service_account needs Vertex AI credentials, not an API key", ErrInvalidImpersonation)
+	}
+	for _, account := range append([]string{c.ImpersonateServiceAccount}, c.ImpersonateDelegates...) {
+		if !strings.Contains(account, "@") {
+			return fmt.Errorf("%w: %q is not a service account email", ErrInvalidImpersonation, account)
+		}
+	}
+	return nil
+}
+
+// impersonated returns a token source that impersonates PLUGIN_IMPERSONATE_SERVICE_ACCOUNT
+// with tokens of base, or base itself without impersonation
+func (c *GeminiClient) impersonated(base TokenSource) TokenSource {
+	cfg := c.config
+	if cfg.ImpersonateServiceAccount == "" {
+		return base
+	}
+	impersonationURL := NewEndpointResolver(cfg).ImpersonationURL(cfg.ImpersonateServiceAccount)
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		baseToken, err := base.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		token, err := c.generateAccessToken(ctx, impersonationURL, baseToken, cfg.ImpersonateDelegates, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.ImpersonateServiceAccount, err)
+		}
+		return token, nil
+	})
+}
+
+// generateAccessTokenRequest is the body of the IAM Credentials generateAccessToken call
+type generateAccessTokenRequest struct {
+	Scope     []string `json:"scope"`
+	Delegates []string `json:"delegates,omitempty"`
+	Lifetime  string   `json:"lifetime,omitempty"`
+}
+
+// generateAccessTokenResponse is the token issued for the impersonated service account
+type generateAccessTokenResponse struct {
+	AccessToken string    `json:"accessToken"`
+	ExpireTime  time.Time `json:"expireTime"`
+}
+
+// generateAccessToken calls the IAM Credentials generateAccessToken method at
+// impersonationURL, authorized with baseToken, to get a token for another
+// service account. delegates are service account emails that pass the
+// impersonation along, in order; lifetime is in seconds, 0 for the API default.
+func (c *GeminiClient) generateAccessToken(ctx context.Context, impersonationURL string, baseToken *Token, delegates []string, lifetime int) (*Token, error) {
+	reqBody := generateAccessTokenRequest{Scope: strings.Fields(oauthScopes)}
+	for _, delegate := range delegates {
+		reqBody.Delegates = append(reqBody.Delegates, "projects/-/serviceAccounts/"+delegate)
+	}
+	if lifetime > 0 {
+		reqBody.Lifetime = fmt.Sprintf("%ds", lifetime)
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doWithRetry(ctx, "generateAccessToken", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", impersonationURL, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+baseToken.AccessToken)
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate service account: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generateAccessToken response: %w", err)
+	}
+
+	var tokenResp generateAccessTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse generateAccessToken response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, errors.New("generateAccessToken response contains no accessToken")
+	}
+	return &Token{AccessToken: tokenResp.AccessToken, Expiry: tokenResp.ExpireTime}, nil
+}
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestConfig_ValidateImpersonation(t *testing.T) {
+	vertex := func(account string, delegates ...string) Config {
+		return Config{
+			GCPCredentials:            `{"type":"service_account"}`,
+			GCPProject:                "my-project",
+			ImpersonateServiceAccount: account,
+			ImpersonateDelegates:      delegates,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "no impersonation", config: vertex("")},
+		{name: "service account", config: vertex("team@p.iam.gserviceaccount.com")},
+		{name: "with delegates", config: vertex("team@p.iam.gserviceaccount.com", "ci@p.iam.gserviceaccount.com")},
+		{name: "delegates without service account", config: vertex("", "ci@p.iam.gserviceaccount.com"), wantErr: true},
+		{name: "not an email", config: vertex("team"), wantErr: true},
+		{name: "invalid delegate", config: vertex("team@p.iam.gserviceaccount.com", "ci"), wantErr: true},
+		{name: "api key", config: Config{APIKey: "key", ImpersonateServiceAccount: "team@p.iam.gserviceaccount.com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateSettings()
+			if tt.wantErr && !errors.Is(err, ErrInvalidImpersonation) {
+				t.Errorf("validateSettings() error = %v, want ErrInvalidImpersonation", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("validateSettings() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestExec_Impersonation(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	creds, err := geminitest.ServiceAccountJSON("ci-project", srv.TokenURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := vertexExecConfig(t, srv, creds)
+	cfg.APIEndpoint = ""
+	cfg.ImpersonateServiceAccount = "team-a@my-project.iam.gserviceaccount.com"
+	cfg.ImpersonateDelegates = []string{"broker@ci-project.iam.gserviceaccount.com"}
+
+	if err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	if n := len(srv.RequestsFor(geminitest.MethodToken)); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+	impersonate := srv.RequestsFor(geminitest.MethodGenerateAccessToken)
+	if len(impersonate) != 1 {
+		t.Fatalf("generateAccessToken requests = %d, want one reused by all API calls", len(impersonate))
+	}
+	if want := "/v1/projects/-/serviceAccounts/team-a@my-project.iam.gserviceaccount.com:generateAccessToken"; impersonate[0].Path != want {
+		t.Errorf("path = %q, want %q", impersonate[0].Path, want)
+	}
+	if got := impersonate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.DefaultAccessToken {
+		t.Errorf("generateAccessToken Authorization = %q, want the base credentials token", got)
+	}
+	var body generateAccessTokenRequest
+	if err := impersonate[0].JSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Delegates) != 1 || body.Delegates[0] != "projects/-/serviceAccounts/broker@ci-project.iam.gserviceaccount.com" {
+		t.Errorf("delegates = %v, want the delegate chain in IAM format", body.Delegates)
+	}
+
+	generate := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if got := generate[0].Header.Get("Authorization"); got != "Bearer "+geminitest.ImpersonatedAccessToken {
+		t.Errorf("Authorization = %q, want the impersonated token", got)
+	}
+}
+
+func TestEndpointResolver_ImpersonationURL(t *testing.T) {
+	const account = "team-a@my-project.iam.gserviceaccount.com"
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{"default", "", "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" + account + ":generateAccessToken"},
+		{"gateway", "http://gateway.internal:8080/iam/", "http://gateway.internal:8080/iam/v1/projects/-/serviceAccounts/" + account + ":generateAccessToken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewEndpointResolver(&Config{IAMEndpoint: tt.endpoint}).ImpersonationURL(account); got != tt.want {
+				t.Errorf("ImpersonationURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExec_ImpersonationIAMEndpoint(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	creds, err := geminitest.ServiceAccountJSON("ci-project", srv.TokenURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No transport rewrite: every request must reach the configured endpoints
+	cfg := vertexExecConfig(t, srv, creds)
+	cfg.IAMEndpoint = srv.URL + "/"
+	cfg.ImpersonateServiceAccount = "team-a@my-project.iam.gserviceaccount.com"
+
+	if err := New(cfg).Exec(context.Background()); err != nil {
+		t.Fatalf("Exec() unexpected error: %v", err)
+	}
+
+	impersonate := srv.RequestsFor(geminitest.MethodGenerateAccessToken)
+	if len(impersonate) != 1 {
+		t.Fatalf("generateAccessToken requests = %d, want 1 through PLUGIN_IAM_ENDPOINT", len(impersonate))
+	}
+	if want := "/v1/projects/-/serviceAccounts/team-a@my-project.iam.gserviceaccount.com:generateAccessToken"; impersonate[0].Path != want {
+		t.Errorf("path = %q, want %q", impersonate[0].Path, want)
+	}
+	generate := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(generate) != 1 || generate[0].Header.Get("Authorization") != "Bearer "+geminitest.ImpersonatedAccessToken {
+		t.Errorf("generateContent requests = %+v, want one with the impersonated token", generate)
+	}
+}
+
+func TestExec_ImpersonationDenied(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	// Model check and token count fall back on errors, so each call is denied
+	denied := geminitest.ErrorResponse(http.StatusForbidden, "PERMISSION_DENIED", "iam.serviceAccounts.getAccessToken denied")
+	srv.Enqueue(geminitest.MethodGenerateAccessToken, denied, denied, denied)
+
+	creds, err := geminitest.ServiceAccountJSON("ci-project", srv.TokenURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := vertexExecConfig(t, srv, creds)
+	cfg.ImpersonateServiceAccount = "team-a@my-project.iam.gserviceaccount.com"
+
+	err = New(cfg, WithTransport(srv.Transport())).Exec(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "team-a@my-project.iam.gserviceaccount.com") || !strings.Contains(err.Error(), "PERMISSION_DENIED") {
+		t.Errorf("Exec() error = %v, want the denied service account", err)
+	}
+}
@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"log"
+	"os"
+)
+
+// Logger receives diagnostic lines such as [DEBUG], [WARN], [RETRY] and [TOOL]
+// messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// defaultLogger writes to stdout, where Drone collects the step log
+var defaultLogger Logger = log.New(os.Stdout, "", 0)
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...any) {}
+
+// NopLogger returns a Logger that discards all messages
+func NopLogger() Logger {
+	return nopLogger{}
+}
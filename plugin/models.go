@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ModelInfo describes a model returned by the models endpoint
+type ModelInfo struct {
+	ID               string   // model ID, e.g. gemini-2.5-pro
+	DisplayName      string   // human-readable name, if provided
+	InputTokenLimit  int      // 0 when not reported (Vertex AI publisher models)
+	OutputTokenLimit int      // 0 when not reported (Vertex AI publisher models)
+	Methods          []string // supported generation methods, if reported
+}
+
+// CanGenerate reports whether the model supports generateContent.
+// Models that do not report their methods are assumed to support it.
+func (m ModelInfo) CanGenerate() bool {
+	if len(m.Methods) == 0 {
+		return true
+	}
+	for _, method := range m.Methods {
+		if method == "generateContent" {
+			return true
+		}
+	}
+	return false
+}
+
+// modelResource is a model in the generativelanguage API (Model) or a
+// Vertex AI publisher model (PublisherModel); only common fields are used
+type modelResource struct {
+	Name                       string   `json:"name"`
+	DisplayName                string   `json:"displayName"`
+	InputTokenLimit            int      `json:"inputTokenLimit"`
+	OutputTokenLimit           int      `json:"outputTokenLimit"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+}
+
+// info converts the resource, taking the ID from its name
+// (models/gemini-2.5-pro or publishers/google/models/gemini-2.5-pro)
+func (m modelResource) info() ModelInfo {
+	return ModelInfo{
+		ID:               path.Base(m.Name),
+		DisplayName:      m.DisplayName,
+		InputTokenLimit:  m.InputTokenLimit,
+		OutputTokenLimit: m.OutputTokenLimit,
+		Methods:          m.SupportedGenerationMethods,
+	}
+}
+
+// listModelsResponse is a page of models.list; Vertex AI names the list publisherModels
+type listModelsResponse struct {
+	Models          []modelResource `json:"models"`
+	PublisherModels []modelResource `json:"publisherModels"`
+	NextPageToken   string          `json:"nextPageToken"`
+}
+
+// GetModel looks up a model, returning ErrModelNotFound when it does not exist
+// or the credentials cannot use it
+func (c *GeminiClient) GetModel(ctx context.Context, model string) (*ModelInfo, error) {
+	apiURL, err := NewEndpointResolver(c.config).ModelsURL(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var resource modelResource
+	if err := c.getJSON(ctx, "models.get", apiURL, &resource); err != nil {
+		var apiErr *APIStatusError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s (%s)", ErrModelNotFound, model, apiErr.statusText())
+		}
+		return nil, err
+	}
+
+	info := resource.info()
+	if info.ID == "" || info.ID == "." {
+		info.ID = model
+	}
+	return &info, nil
+}
+
+// ListModels returns all models the credentials can use, following pagination
+func (c *GeminiClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	listURL, err := NewEndpointResolver(c.config).ModelsURL("")
+	if err != nil {
+		return nil, err
+	}
+
+	var models []ModelInfo
+	pageToken := ""
+	for {
+		pageURL, err := url.Parse(listURL)
+		if err != nil {
+			return nil, err
+		}
+		query := pageURL.Query()
+		query.Set("pageSize", "1000")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		pageURL.RawQuery = query.Encode()
+
+		var page listModelsResponse
+		if err := c.getJSON(ctx, "models.list", pageURL.String(), &page); err != nil {
+			return nil, err
+		}
+		for _, resource := range append(page.Models, page.PublisherModels...) {
+			models = append(models, resource.info())
+		}
+
+		if page.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// CheckModels looks up every model of the fallback chain before any context is
+// built, so a misspelled model fails fast. Models that are not found, e.g. a
+// preview model in a region that does not serve it, are dropped from the chain
+// with a warning; only a chain without any available model is an error. Models
+// missing from PricingTable are reported since their costs can only be
+// estimated. If the models endpoint is unavailable the check is skipped with a warning.
+func (c *GeminiClient) CheckModels(ctx context.Context) ([]ModelInfo, error) {
+	models := c.config.Models()
+
+	var (
+		infos     []ModelInfo
+		available []string
+		missing   []error
+	)
+	for i, model := range models {
+		info, err := c.GetModel(ctx, model)
+		if errors.Is(err, ErrModelNotFound) {
+			missing = append(missing, err)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			c.logger.Printf("[WARN] Cannot validate model %s, skipping model check: %v\n", model, err)
+			available = append(available, models[i:]...)
+			infos = nil
+			break
+		}
+
+		if !info.CanGenerate() {
+			return nil, fmt.Errorf("%w: %s does not support generateContent", ErrModelNotFound, model)
+		}
+		if !HasPricing(model) {
+			c.logger.Printf("[WARN] No pricing known for model %s, costs are estimated with default prices\n", model)
+		}
+		available = append(available, model)
+		infos = append(infos, *info)
+	}
+
+	switch {
+	case len(available) == 0 && len(missing) == 1:
+		return nil, fmt.Errorf("%w: check PLUGIN_MODEL, the models command lists the available models", missing[0])
+	case len(available) == 0 && len(missing) > 0:
+		return nil, fmt.Errorf("%w: none of %s is available, check PLUGIN_MODEL, the models command lists the available models", ErrModelNotFound, strings.Join(models, ", "))
+	case len(missing) > 0:
+		for _, err := range missing {
+			c.logger.Printf("[WARN] %v, removing it from the fallback chain\n", err)
+		}
+		// Generation, token counting and pricing use the narrowed chain
+		c.config.Model = strings.Join(available, ",")
+	}
+	return infos, nil
+}
+
+// getJSON sends an authenticated GET request and decodes the JSON response into v
+func (c *GeminiClient) getJSON(ctx context.Context, desc, apiURL string, v any) error {
+	authHeader, err := c.authorization(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.config.Debug {
+		maskedURL := apiURL
+		if c.config.APIKey != "" {
+			maskedURL = strings.Replace(apiURL, c.config.APIKey, "***", 1)
+		}
+		c.logger.Printf("[DEBUG] %s URL: %s\n", desc, maskedURL)
+	}
+
+	resp, err := c.doWithRetry(ctx, desc, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", desc, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", desc, err)
+	}
+	return nil
+}
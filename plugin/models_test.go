@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestEndpointResolver_ModelsURL(t *testing.T) {
+	vertex := func(location string) Config {
+		return Config{GCPCredentials: `{"type":"service_account"}`, GCPProject: "my-project", GCPLocation: location}
+	}
+
+	tests := []struct {
+		name     string
+		config   Config
+		model    string
+		expected string
+	}{
+		{"AI Studio get", Config{APIKey: "test-key"}, "gemini-2.5-pro", "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro?key=test-key"},
+		{"AI Studio list", Config{APIKey: "test-key"}, "", "https://generativelanguage.googleapis.com/v1beta/models?key=test-key"},
+		{"Vertex AI regional get", vertex("us-central1"), "gemini-2.5-pro", "https://us-central1-aiplatform.googleapis.com/v1/publishers/google/models/gemini-2.5-pro"},
+		{"Vertex AI regional list", vertex("us-central1"), "", "https://us-central1-aiplatform.googleapis.com/v1beta1/publishers/google/models"},
+		{"Vertex AI global get", vertex("global"), "gemini-2.5-pro", "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEndpointResolver(&tt.config).ModelsURL(tt.model)
+			if err != nil {
+				t.Fatalf("ModelsURL() unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ModelsURL() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHasPricing(t *testing.T) {
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"gemini-2.5-pro", true},
+		{"gemini-2.5-flash-001", true},
+		{"gemini-exp-1206", false},
+		{"text-embedding-004", false},
+	}
+
+	for _, tt := range tests {
+		if got := HasPricing(tt.model); got != tt.want {
+			t.Errorf("HasPricing(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+
+	// Versioned IDs use the most specific pricing entry
+	if got := NewCostCalculator("gemini-2.5-flash-lite-001").pricing.Name; got != "Gemini 2.5 Flash-Lite" {
+		t.Errorf("pricing of gemini-2.5-flash-lite-001 = %q, want Gemini 2.5 Flash-Lite", got)
+	}
+}
+
+func TestGeminiClient_CheckModels(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.SetModels("gemini-2.5-pro", "gemini-2.5-flash")
+
+	cfg := execConfig(t)
+	cfg.Model = "gemini-2.5-pro, gemini-2.5-flash"
+	infos, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).CheckModels(context.Background())
+	if err != nil {
+		t.Fatalf("CheckModels() unexpected error: %v", err)
+	}
+	if len(infos) != 2 || infos[0].ID != "gemini-2.5-pro" || infos[0].InputTokenLimit != 1048576 || infos[0].OutputTokenLimit != 65536 {
+		t.Errorf("CheckModels() = %+v, want both models with their limits", infos)
+	}
+}
+
+func TestGeminiClient_CheckModelsUnavailable(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGetModel, geminitest.ErrorResponse(http.StatusForbidden, "PERMISSION_DENIED", "models.get is not allowed"))
+
+	cfg := execConfig(t)
+	infos, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).CheckModels(context.Background())
+	if err != nil || infos != nil {
+		t.Fatalf("CheckModels() = %v, %v, want the check skipped", infos, err)
+	}
+}
+
+func TestExec_UnknownModel(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.SetModels("gemini-2.5-pro", "gemini-2.5-flash")
+
+	cfg := execConfig(t)
+	cfg.Model = "gemini-2.5-flsh"
+
+	err := New(cfg, WithTransport(srv.Transport())).Exec(context.Background())
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("Exec() error = %v, want ErrModelNotFound", err)
+	}
+	if requests := srv.Requests(); len(requests) != 1 {
+		t.Errorf("requests = %d, want only the model lookup", len(requests))
+	}
+}
+
+func TestReviewer_UnavailablePrimaryModel(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.SetModels("gemini-2.5-pro", "gemini-2.5-flash")
+
+	cfg := execConfig(t)
+	cfg.Model = "gemini-3-pro-preview, gemini-2.5-flash"
+	logger := &captureLogger{}
+	reviewer, err := NewReviewer(WithConfig(cfg), WithLogger(logger), WithClientOptions(WithTransport(srv.Transport())))
+	if err != nil {
+		t.Fatalf("NewReviewer() unexpected error: %v", err)
+	}
+
+	result, err := reviewer.Review(context.Background(), "Review this code")
+	if err != nil {
+		t.Fatalf("Review() unexpected error: %v", err)
+	}
+
+	for _, method := range []string{geminitest.MethodCountTokens, geminitest.MethodGenerateContent} {
+		requests := srv.RequestsFor(method)
+		if len(requests) != 1 || requests[0].Model != "gemini-2.5-flash" {
+			t.Errorf("%s requests = %+v, want one to the fallback model", method, requests)
+		}
+	}
+	if len(result.Models) != 1 || result.Models[0].ID != "gemini-2.5-flash" {
+		t.Errorf("Models = %+v, want only the fallback model", result.Models)
+	}
+	if !strings.Contains(logger.String(), "gemini-3-pro-preview") || !strings.Contains(logger.String(), "removing it from the fallback chain") {
+		t.Errorf("logs do not report the dropped model:\n%s", logger.String())
+	}
+}
+
+func TestGeminiClient_CheckModelsNoneAvailable(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.SetModels("gemini-2.5-pro")
+
+	cfg := execConfig(t)
+	cfg.Model = "gemini-3-pro-preview, gemini-2.5-flsh"
+	_, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).CheckModels(context.Background())
+	if !errors.Is(err, ErrModelNotFound) || !strings.Contains(err.Error(), "none of gemini-3-pro-preview, gemini-2.5-flsh") {
+		t.Errorf("CheckModels() error = %v, want ErrModelNotFound naming the chain", err)
+	}
+}
+
+func TestGeminiClient_ListModels(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodListModels,
+		geminitest.JSONResponse(map[string]any{
+			"models":        []any{map[string]any{"name": "models/gemini-2.5-pro", "supportedGenerationMethods": []string{"generateContent"}}},
+			"nextPageToken": "page-2",
+		}),
+		geminitest.JSONResponse(map[string]any{
+			"models": []any{map[string]any{"name": "models/text-embedding-004", "supportedGenerationMethods": []string{"embedContent"}}},
+		}),
+	)
+
+	cfg := execConfig(t)
+	models, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() unexpected error: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "gemini-2.5-pro" || models[1].ID != "text-embedding-004" {
+		t.Fatalf("ListModels() = %+v, want both pages", models)
+	}
+	if !models[0].CanGenerate() || models[1].CanGenerate() {
+		t.Error("CanGenerate() does not follow supportedGenerationMethods")
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodListModels)
+	if len(requests) != 2 || requests[1].Query.Get("pageToken") != "page-2" {
+		t.Errorf("list requests = %+v, want the second page requested with its token", requests)
+	}
+}
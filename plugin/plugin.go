@@ -1,76 +1,311 @@
 package plugin
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
 // Plugin represents the drone-gemini-plugin
 type Plugin struct {
-	config Config
+	config        Config
+	clientOptions []ClientOption
 }
 
 // New creates a new plugin instance
-func New(cfg Config) *Plugin {
+func New(cfg Config, opts ...ClientOption) *Plugin {
 	return &Plugin{
-		config: cfg,
+		config:        cfg,
+		clientOptions: opts,
 	}
 }
 
-// Exec runs the plugin and returns any error encountered
-func (p *Plugin) Exec() error {
-	// Validate configuration
-	if err := p.config.Validate(); err != nil {
+// Exec runs the plugin and returns any error encountered.
+// Cancelling ctx (e.g. on SIGTERM when the build is cancelled) stops all work.
+func (p *Plugin) Exec(ctx context.Context) error {
+	if p.config.Prompt == "" {
+		return ErrPromptRequired
+	}
+
+	// Validate configuration, completed from Application Default Credentials
+	reviewer, err := p.reviewer(ctx)
+	if err != nil {
 		return err
 	}
+	p.config = reviewer.Config()
 
 	// Detect authentication mode
 	authMode := p.config.DetectAuthMode()
 
+	// Build the request first so the summary can show its exact token count
+	session, err := reviewer.Start(ctx, p.config.Prompt)
+	if err != nil {
+		return err
+	}
+
 	// Display configuration summary
-	p.displayConfig(authMode)
+	review := session.Result()
+	p.displayConfig(authMode, review.PromptTokens, review.Models)
 
 	// Execute AI analysis
 	fmt.Println("Executing AI analysis...")
 	fmt.Println()
 
-	// Create Gemini client and generate content
-	client := NewGeminiClient(&p.config)
-	output, usageStats, err := client.GenerateContent()
+	// In streaming mode the result is printed while it is generated
+	if p.config.Stream {
+		fmt.Println("=== AI Analysis Result ===")
+		fmt.Println()
+	}
+
+	result, err := session.Generate(ctx)
+	if p.config.Stream {
+		fmt.Println()
+	}
+	if errors.Is(err, context.Canceled) {
+		p.displayCancelled(session.Result().Usage)
+		return err
+	}
 	if err != nil {
 		return err
 	}
 
 	// Display AI output
-	fmt.Println("=== AI Analysis Result ===")
-	fmt.Println()
-	fmt.Println(output)
+	if !p.config.Stream {
+		fmt.Println("=== AI Analysis Result ===")
+		fmt.Println()
+	}
+	p.displayResult(result)
+
+	// Follow-up prompts continue the same conversation, reusing the code context
+	for i, prompt := range p.config.FollowUps {
+		fmt.Println()
+		fmt.Printf("=== Follow-up %d/%d: %s ===\n", i+1, len(p.config.FollowUps), truncateString(prompt, 80))
+		fmt.Println()
 
-	// Display cost statistics
-	if usageStats != nil {
-		fmt.Print(usageStats.FormatCostSummary())
+		result, err = session.FollowUp(ctx, prompt)
+		if p.config.Stream {
+			fmt.Println()
+		}
+		if errors.Is(err, context.Canceled) {
+			p.displayCancelled(session.Result().Usage)
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("follow-up %d: %w", i+1, err)
+		}
+
+		p.displayResult(result)
 	}
 
+	// Display cost statistics of the whole conversation
+	fmt.Print(session.Result().Usage.FormatCostSummary())
+
 	return nil
 }
 
-// displayConfig shows the current configuration
-func (p *Plugin) displayConfig(authMode AuthMode) {
+// reviewer creates the library reviewer the plugin drives
+func (p *Plugin) reviewer(ctx context.Context) (*Reviewer, error) {
+	return NewReviewerContext(ctx, WithConfig(p.config), WithClientOptions(p.clientOptions...))
+}
+
+// ListModels prints the models the configured credentials can use for generateContent
+func (p *Plugin) ListModels(ctx context.Context) error {
+	reviewer, err := p.reviewer(ctx)
+	if err != nil {
+		return err
+	}
+	p.config = reviewer.Config()
+
+	models, err := reviewer.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list models: %w", err)
+	}
+
+	fmt.Printf("Models available with the %s:\n\n", NewEndpointResolver(&p.config).Describe())
+	fmt.Printf("%-40s %12s %12s  %s\n", "MODEL", "INPUT", "OUTPUT", "PRICING")
+	for _, model := range models {
+		if !model.CanGenerate() {
+			continue
+		}
+		pricing := "unknown"
+		if HasPricing(model.ID) {
+			pricing = NewCostCalculator(model.ID).pricing.Name
+		}
+		fmt.Printf("%-40s %12s %12s  %s\n", model.ID, formatTokenLimit(model.InputTokenLimit), formatTokenLimit(model.OutputTokenLimit), pricing)
+	}
+	return nil
+}
+
+// formatTokenLimit formats a token limit, which Vertex AI does not report
+func formatTokenLimit(limit int) string {
+	if limit <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", limit)
+}
+
+// displayResult prints the answer of one turn with its thought summaries.
+// In streaming mode the answer was already printed while it was generated.
+func (p *Plugin) displayResult(result *GenerateResult) {
+	if !p.config.Stream {
+		fmt.Println(formatOutput(result))
+	}
+
+	// Display thought summaries separately from the review text
+	if result.Thoughts != "" {
+		fmt.Print(formatReasoning(result.Thoughts))
+	}
+
+	// Per-turn usage; the summary at the end covers the whole conversation
+	if len(p.config.FollowUps) > 0 {
+		fmt.Printf("\n(%s)\n", result.Usage.FormatCostSummarySimple())
+	}
+}
+
+// displayCancelled reports a cancelled run with the usage consumed so far
+func (p *Plugin) displayCancelled(usage *UsageStats) {
+	fmt.Println()
+	fmt.Println("=== AI analysis cancelled ===")
+
+	if usage == nil {
+		fmt.Println("No usage data available for the cancelled request.")
+		return
+	}
+
+	fmt.Println("Partial usage before cancellation:")
+	fmt.Print(usage.FormatCostSummary())
+}
+
+// displayConfig shows the current configuration with the primary model's
+// token limits when the models endpoint reported them
+func (p *Plugin) displayConfig(authMode AuthMode, tokens PromptTokens, models []ModelInfo) {
 	fmt.Println()
 	fmt.Println("--- Configuration ---")
 	fmt.Printf("Target: %s\n", p.config.Target)
-	fmt.Printf("Model: %s\n", p.config.Model)
+	if models := p.config.Models(); len(models) > 1 {
+		fmt.Printf("Model: %s (fallback: %s)\n", models[0], strings.Join(models[1:], ", "))
+	} else {
+		fmt.Printf("Model: %s\n", p.config.Model)
+	}
 	fmt.Printf("Prompt: %s\n", truncateString(p.config.Prompt, 100))
 	fmt.Printf("Timeout: %ds\n", p.config.Timeout)
 
+	// Priced for the primary model; a fallback model may differ
+	inputCost := NewCostCalculator(p.config.PrimaryModel()).CalculateCost(tokens.Count, 0, 0).InputCost
+	if tokens.Exact {
+		fmt.Printf("Prompt Tokens: %d (predicted input cost $%.6f)\n", tokens.Count, inputCost)
+	} else {
+		fmt.Printf("Prompt Tokens: ~%d estimated (predicted input cost $%.6f)\n", tokens.Count, inputCost)
+	}
+
+	if len(models) > 0 && models[0].InputTokenLimit > 0 {
+		limits := models[0]
+		fmt.Printf("Model Limits: %d input / %d output tokens\n", limits.InputTokenLimit, limits.OutputTokenLimit)
+		if tokens.Count > limits.InputTokenLimit {
+			fmt.Printf("[WARN] Prompt exceeds the input token limit of %s, lower PLUGIN_MAX_CONTEXT_SIZE or PLUGIN_MAX_FILES\n", limits.ID)
+		}
+	}
+
+	if p.config.SystemInstructionFile != "" {
+		fmt.Printf("System Instruction: %s\n", p.config.SystemInstructionFile)
+	} else if p.config.SystemInstruction != "" {
+		fmt.Printf("System Instruction: %s\n", truncateString(p.config.SystemInstruction, 100))
+	}
+
 	if p.config.GitDiff {
 		fmt.Println("Git Diff: enabled")
 	}
 
+	if p.config.Stream {
+		fmt.Println("Stream: enabled")
+	}
+
+	if p.config.Temperature != nil {
+		fmt.Printf("Temperature: %g\n", *p.config.Temperature)
+	}
+
+	if p.config.Seed != nil {
+		fmt.Printf("Seed: %d\n", *p.config.Seed)
+	}
+
+	if p.config.Agent {
+		if p.config.AgentTokenBudget > 0 {
+			fmt.Printf("Agent Tools: enabled (max %d steps, %d token budget)\n", p.config.AgentMaxSteps, p.config.AgentTokenBudget)
+		} else {
+			fmt.Printf("Agent Tools: enabled (max %d steps)\n", p.config.AgentMaxSteps)
+		}
+	}
+
+	if tools := builtinToolNames(&p.config); len(tools) > 0 {
+		fmt.Printf("Built-in Tools: %s\n", strings.Join(tools, ", "))
+	}
+
+	if len(p.config.FollowUps) > 0 {
+		fmt.Printf("Follow-ups: %d\n", len(p.config.FollowUps))
+	}
+
+	if p.config.ResponseSchema != "" {
+		fmt.Printf("Response Schema: %s\n", p.config.ResponseSchema)
+	}
+
+	if p.config.ThinkingBudget != nil {
+		fmt.Printf("Thinking Budget: %d\n", *p.config.ThinkingBudget)
+	}
+
+	if p.config.ThinkingLevel != "" {
+		fmt.Printf("Thinking Level: %s\n", p.config.ThinkingLevel)
+	}
+
+	if p.config.MaxOutputTokens > 0 {
+		fmt.Printf("Max Output Tokens: %d\n", p.config.MaxOutputTokens)
+	}
+
 	if p.config.MaxFiles > 0 {
 		fmt.Printf("Max Files: %d\n", p.config.MaxFiles)
 	}
 
+	if p.config.APIEndpoint != "" {
+		fmt.Printf("API Endpoint: %s\n", p.config.APIEndpoint)
+	}
+
+	if p.config.IAMEndpoint != "" {
+		fmt.Printf("IAM Endpoint: %s\n", p.config.IAMEndpoint)
+	}
+
+	if p.config.RecordDir != "" {
+		fmt.Printf("Record: %s\n", p.config.RecordDir)
+	}
+
+	if p.config.ReplayDir != "" {
+		fmt.Printf("Replay: %s (no network access)\n", p.config.ReplayDir)
+	}
+
+	if p.config.Proxy != "" {
+		fmt.Printf("Proxy: %s\n", redactURL(p.config.Proxy))
+	}
+
+	if p.config.CABundle != "" {
+		fmt.Println("CA Bundle: configured")
+	}
+
+	if p.config.ClientCert != "" {
+		fmt.Println("Client Certificate: configured (mTLS)")
+	}
+
+	if authMode == AuthModeVertexAI {
+		fmt.Printf("Credentials: %s\n", p.config.CredentialsSource())
+	}
+
+	if p.config.ImpersonateServiceAccount != "" {
+		fmt.Printf("Impersonating: %s\n", p.config.ImpersonateServiceAccount)
+		if len(p.config.ImpersonateDelegates) > 0 {
+			fmt.Printf("Delegates: %s\n", strings.Join(p.config.ImpersonateDelegates, " -> "))
+		}
+	}
+
 	if authMode == AuthModeVertexAI || p.config.GCPProject != "" {
 		fmt.Printf("GCP Project: %s\n", p.config.GCPProject)
 		fmt.Printf("GCP Location: %s\n", p.config.GCPLocation)
@@ -79,6 +314,35 @@ func (p *Plugin) displayConfig(authMode AuthMode) {
 	fmt.Println()
 }
 
+// formatOutput returns the answer text, pretty-printing structured JSON output
+func formatOutput(result *GenerateResult) string {
+	if result.Structured != nil {
+		if pretty, err := json.MarshalIndent(result.Structured, "", "  "); err == nil {
+			return string(pretty)
+		}
+	}
+	return result.Text
+}
+
+// formatReasoning wraps thought summaries in a collapsible Markdown section
+func formatReasoning(thoughts string) string {
+	var sb strings.Builder
+	sb.WriteString("\n<details>\n")
+	sb.WriteString("<summary>Reasoning</summary>\n\n")
+	sb.WriteString(strings.TrimSpace(thoughts))
+	sb.WriteString("\n\n</details>\n")
+	return sb.String()
+}
+
+// redactURL hides credentials embedded in a URL (e.g. proxy user:password)
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "***"
+	}
+	return u.Redacted()
+}
+
 // truncateString truncates a string to max length with ellipsis
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
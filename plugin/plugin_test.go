@@ -1,6 +1,9 @@
 package plugin
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -46,7 +49,7 @@ func TestConfig_DetectAuthMode(t *testing.T) {
 			config: Config{
 				GCPCredentials: `{"type":"service_account"}`,
 			},
-			expected: AuthModeNone, // No valid auth mode without project
+			expected: AuthModeVertexAI, // Validate requires a project if none is detected
 		},
 	}
 
@@ -100,6 +103,15 @@ func TestConfig_Validate(t *testing.T) {
 			expectError: true,
 			errorType:   ErrNoCredentials,
 		},
+		{
+			name: "Vertex AI without project",
+			config: Config{
+				Prompt:         "Review this code",
+				GCPCredentials: `{"type":"service_account"}`,
+			},
+			expectError: true,
+			errorType:   ErrProjectRequired,
+		},
 	}
 
 	for _, tt := range tests {
@@ -118,6 +130,50 @@ func TestConfig_Validate(t *testing.T) {
 	}
 }
 
+func TestConfig_Models(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected []string
+	}{
+		{"gemini-2.5-pro", []string{"gemini-2.5-pro"}},
+		{"gemini-3-pro-preview, gemini-2.5-pro,,gemini-2.5-flash ", []string{"gemini-3-pro-preview", "gemini-2.5-pro", "gemini-2.5-flash"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Model: tt.model}
+		if got := cfg.Models(); strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("Models() for %q = %v, want %v", tt.model, got, tt.expected)
+		}
+	}
+}
+
+func TestStringList_Decode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{`["Summarize, briefly", "List the risks"]`, []string{"Summarize, briefly", "List the risks"}},
+		{"Summarize, briefly\n\n  List the risks  \n", []string{"Summarize, briefly", "List the risks"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		var list StringList
+		if err := list.Decode(tt.value); err != nil {
+			t.Fatalf("Decode(%q) unexpected error: %v", tt.value, err)
+		}
+		if strings.Join(list, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("Decode(%q) = %q, want %q", tt.value, list, tt.expected)
+		}
+	}
+
+	var list StringList
+	if err := list.Decode(`["unterminated`); err == nil {
+		t.Error("Decode() expected error for invalid JSON")
+	}
+}
+
 func TestTruncateString(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -195,3 +251,150 @@ func TestEstimateTokens(t *testing.T) {
 		t.Errorf("EstimateTokens(%q) = %d, expected around 11", text, estimate)
 	}
 }
+
+func TestConfig_ValidateGenerationConfig(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+	integer := func(v int) *int { return &v }
+
+	tests := []struct {
+		name        string
+		modify      func(*Config)
+		expectError bool
+	}{
+		{"No generation settings", func(c *Config) {}, false},
+		{"Valid settings", func(c *Config) {
+			c.Temperature = float(0)
+			c.TopP = float(0.95)
+			c.TopK = integer(40)
+			c.Seed = integer(42)
+			c.MaxOutputTokens = 8192
+			c.StopSequences = []string{"END"}
+		}, false},
+		{"Temperature too high", func(c *Config) { c.Temperature = float(2.5) }, true},
+		{"TopP out of range", func(c *Config) { c.TopP = float(1.5) }, true},
+		{"TopK zero", func(c *Config) { c.TopK = integer(0) }, true},
+		{"Too many candidates", func(c *Config) { c.CandidateCount = 9 }, true},
+		{"Candidates with response schema", func(c *Config) { c.CandidateCount = 2; c.ResponseSchema = "findings" }, true},
+		{"Candidates with follow-ups", func(c *Config) { c.CandidateCount = 2; c.FollowUps = StringList{"Anything else?"} }, true},
+		{"Candidates in agent mode", func(c *Config) { c.CandidateCount = 2; c.Agent = true; c.AgentMaxSteps = 5 }, true},
+		{"Candidates with continuations", func(c *Config) { c.CandidateCount = 2; c.MaxContinuations = 3 }, false},
+		{"Single candidate in agent mode", func(c *Config) { c.CandidateCount = 1; c.Agent = true; c.AgentMaxSteps = 5 }, false},
+		{"Negative max output tokens", func(c *Config) { c.MaxOutputTokens = -1 }, true},
+		{"Too many stop sequences", func(c *Config) { c.StopSequences = []string{"a", "b", "c", "d", "e", "f"} }, true},
+		{"Presence penalty out of range", func(c *Config) { c.PresencePenalty = float(2) }, true},
+		{"Frequency penalty out of range", func(c *Config) { c.FrequencyPenalty = float(-2.5) }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Prompt: "Review this code", APIKey: "test-key"}
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidGenerationConfig) {
+					t.Errorf("Validate() error = %v, want ErrInvalidGenerationConfig", err)
+				}
+			} else if err != nil {
+				t.Errorf("Validate() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildGenerationConfig(t *testing.T) {
+	client := NewGeminiClient(&Config{})
+	if got, _ := client.buildGenerationConfig(); got != nil {
+		t.Errorf("buildGenerationConfig() = %+v, want nil when nothing is set", got)
+	}
+
+	temperature := 0.0
+	client = NewGeminiClient(&Config{Temperature: &temperature, MaxOutputTokens: 1024})
+	genCfg, err := client.buildGenerationConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(genCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"temperature":0,"maxOutputTokens":1024}` {
+		t.Errorf("generationConfig JSON = %s, want explicit zero temperature", data)
+	}
+}
+
+func TestConfig_ValidateThinkingConfig(t *testing.T) {
+	budget := func(v int) *int { return &v }
+
+	tests := []struct {
+		name        string
+		config      Config
+		expectError bool
+	}{
+		{"Budget for 2.5 model", Config{Model: "gemini-2.5-pro", ThinkingBudget: budget(1024)}, false},
+		{"Dynamic budget", Config{Model: "gemini-2.5-flash", ThinkingBudget: budget(-1)}, false},
+		{"Level for 3 model", Config{Model: "gemini-3-pro-preview", ThinkingLevel: "LOW"}, false},
+		{"Level for 2.5 model", Config{Model: "gemini-2.5-pro", ThinkingLevel: "low"}, true},
+		{"Unknown level", Config{Model: "gemini-3-pro-preview", ThinkingLevel: "extreme"}, true},
+		{"Budget and level together", Config{Model: "gemini-3-pro-preview", ThinkingBudget: budget(0), ThinkingLevel: "low"}, true},
+		{"Invalid budget", Config{Model: "gemini-2.5-pro", ThinkingBudget: budget(-2)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Prompt = "Review this code"
+			tt.config.APIKey = "test-key"
+
+			err := tt.config.Validate()
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidThinkingConfig) {
+					t.Errorf("Validate() error = %v, want ErrInvalidThinkingConfig", err)
+				}
+			} else if err != nil {
+				t.Errorf("Validate() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractText_Candidates(t *testing.T) {
+	resp := &GenerateContentResponse{
+		Candidates: []Candidate{
+			{Content: Content{Role: "model", Parts: []Part{{Text: "Looking at main.go", Thought: true}, {Text: "No issues found.\n"}}}},
+			{Content: Content{Role: "model", Parts: []Part{{Text: "One issue: "}, {Text: "missing error check."}}}},
+		},
+	}
+
+	text, thoughts := extractText(resp)
+	want := "## Candidate 1\n\nNo issues found.\n\n## Candidate 2\n\nOne issue: missing error check."
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if thoughts != "## Candidate 1\n\nLooking at main.go" {
+		t.Errorf("thoughts = %q, want the thoughts of the first candidate only", thoughts)
+	}
+}
+
+func TestExtractText_Thoughts(t *testing.T) {
+	resp := &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: Content{Role: "model", Parts: []Part{
+				{Text: "Checking the error handling...", Thought: true},
+				{Text: "No issues found."},
+			}},
+		}},
+	}
+
+	text, thoughts := extractText(resp)
+	if text != "No issues found." {
+		t.Errorf("text = %q, want answer only", text)
+	}
+	if thoughts != "Checking the error handling..." {
+		t.Errorf("thoughts = %q, want thought summary", thoughts)
+	}
+
+	reasoning := formatReasoning(thoughts)
+	if !strings.Contains(reasoning, "<summary>Reasoning</summary>") || !strings.Contains(reasoning, thoughts) {
+		t.Errorf("formatReasoning() = %q, want collapsible Reasoning section", reasoning)
+	}
+}
@@ -92,7 +92,10 @@ var PricingTable = map[string]ModelPricing{
 
 // UsageStats holds token usage statistics
 type UsageStats struct {
-	Model          string
+	Model          string   // display name of the model that answered
+	ModelID        string   // API model ID that answered, e.g. gemini-2.5-pro
+	SkippedModels  []string // earlier models of the fallback chain and why they were skipped
+	Continuations  int      // extra requests made to continue answers cut off at MAX_TOKENS
 	InputTokens    int
 	OutputTokens   int
 	ThoughtsTokens int // Thinking tokens for reasoning models (billed as output)
@@ -111,20 +114,37 @@ type CostCalculator struct {
 	pricing ModelPricing
 }
 
-// NewCostCalculator creates a new cost calculator for a model
-func NewCostCalculator(model string) *CostCalculator {
+// lookupPricing finds the pricing of a model by exact or partial name match,
+// so versioned IDs such as gemini-2.5-flash-001 use the base model's pricing
+func lookupPricing(model string) (ModelPricing, bool) {
 	// Try to find exact match first
-	pricing, ok := PricingTable[model]
-	if !ok {
-		// Try partial match
-		for key, p := range PricingTable {
-			if strings.Contains(strings.ToLower(model), strings.ToLower(key)) {
-				pricing = p
-				ok = true
-				break
-			}
+	if pricing, ok := PricingTable[model]; ok {
+		return pricing, true
+	}
+
+	// Try partial match, preferring the longest key so gemini-2.5-flash-lite
+	// is not priced as gemini-2.5-flash
+	var match string
+	for key := range PricingTable {
+		if strings.Contains(strings.ToLower(model), strings.ToLower(key)) && len(key) > len(match) {
+			match = key
 		}
 	}
+	if match == "" {
+		return ModelPricing{}, false
+	}
+	return PricingTable[match], true
+}
+
+// HasPricing reports whether PricingTable knows the model
+func HasPricing(model string) bool {
+	_, ok := lookupPricing(model)
+	return ok
+}
+
+// NewCostCalculator creates a new cost calculator for a model
+func NewCostCalculator(model string) *CostCalculator {
+	pricing, ok := lookupPricing(model)
 
 	// Default pricing if model not found
 	if !ok {
@@ -158,6 +178,7 @@ func (c *CostCalculator) EstimateTokens(text string) int {
 func (c *CostCalculator) CalculateCost(inputTokens, outputTokens, thoughtsTokens int) *UsageStats {
 	stats := &UsageStats{
 		Model:          c.pricing.Name,
+		ModelID:        c.model,
 		InputTokens:    inputTokens,
 		OutputTokens:   outputTokens,
 		ThoughtsTokens: thoughtsTokens,
@@ -180,6 +201,25 @@ func (c *CostCalculator) CalculateCost(inputTokens, outputTokens, thoughtsTokens
 	return stats
 }
 
+// Add accumulates usage and cost from another call, e.g. a retry or follow-up turn
+func (stats *UsageStats) Add(other *UsageStats) {
+	if other == nil {
+		return
+	}
+	stats.InputTokens += other.InputTokens
+	stats.OutputTokens += other.OutputTokens
+	stats.ThoughtsTokens += other.ThoughtsTokens
+	stats.TotalTokens += other.TotalTokens
+	stats.EstimatedInput += other.EstimatedInput
+	stats.InputCost += other.InputCost
+	stats.OutputCost += other.OutputCost
+	stats.ThoughtsCost += other.ThoughtsCost
+	stats.TotalCost += other.TotalCost
+	stats.IsLongContext = stats.IsLongContext || other.IsLongContext
+	stats.SkippedModels = append(stats.SkippedModels, other.SkippedModels...)
+	stats.Continuations += other.Continuations
+}
+
 // FormatCostSummary formats the usage stats as a readable string
 func (stats *UsageStats) FormatCostSummary() string {
 	var sb strings.Builder
@@ -189,6 +229,13 @@ func (stats *UsageStats) FormatCostSummary() string {
 	sb.WriteString("|                    Token Usage Statistics                     |\n")
 	sb.WriteString("+--------------------------------------------------------------+\n")
 	sb.WriteString(fmt.Sprintf("|  Model: %-53s |\n", stats.Model))
+	for _, skipped := range stats.SkippedModels {
+		sb.WriteString(fmt.Sprintf("|  Skipped: %-51s |\n", truncateString(skipped, 51)))
+	}
+
+	if stats.Continuations > 0 {
+		sb.WriteString(fmt.Sprintf("|  Continuations: %-45d |\n", stats.Continuations))
+	}
 
 	if stats.EstimatedInput > 0 {
 		sb.WriteString(fmt.Sprintf("|  Estimated Input: %-43d |\n", stats.EstimatedInput))
@@ -0,0 +1,232 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed API calls are retried
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first one
+	BaseDelay   time.Duration // delay before the first retry, doubled on each attempt
+	MaxDelay    time.Duration // upper bound for the computed backoff delay
+	Jitter      float64       // fraction of the delay to randomize (0.0 - 1.0)
+}
+
+// NewRetryPolicy creates a retry policy from the plugin configuration
+func NewRetryPolicy(cfg *Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   time.Duration(cfg.RetryBaseDelay) * time.Millisecond,
+		MaxDelay:    time.Duration(cfg.RetryMaxDelay) * time.Millisecond,
+		Jitter:      cfg.RetryJitter,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// Backoff returns the delay before the given retry (1 = first retry)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(retry-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// Spread retries of concurrent pipelines: delay * (1 +/- jitter)
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// isRetryableStatus reports whether an HTTP status is worth retrying.
+// Permanent failures like 400 (bad request) or 403 (permission denied) are not.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// newAPIStatusError builds a typed error from a non-200 response
+func newAPIStatusError(resp *http.Response, body []byte) *APIStatusError {
+	apiErr := &APIStatusError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+
+	// Google APIs return {"error": {"code", "message", "status", "details"}}
+	var envelope struct {
+		Error *APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil {
+		apiErr.Status = envelope.Error.Status
+		apiErr.Message = envelope.Error.Message
+		apiErr.RetryDelay = envelope.Error.retryDelay()
+	}
+
+	// Retry-After header takes precedence over the RetryInfo detail
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		apiErr.RetryDelay = delay
+	}
+
+	return apiErr
+}
+
+// fallbackReason reports whether err should move generation to the next model
+// of the fallback chain, with a short reason for the logs and cost summary
+func fallbackReason(err error) (string, bool) {
+	var apiErr *APIStatusError
+	hasStatus := errors.As(err, &apiErr)
+
+	switch {
+	case errors.Is(err, ErrRetriesExhausted):
+		if hasStatus {
+			return fmt.Sprintf("returned %s after retries", apiErr.statusText()), true
+		}
+		return "failed after retries", true
+	case hasStatus && apiErr.StatusCode == http.StatusNotFound:
+		return fmt.Sprintf("is not available (%s)", apiErr.statusText()), true
+	}
+	return "", false
+}
+
+// parseRetryAfter parses a Retry-After header (delay in seconds or HTTP date)
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		delay := time.Until(when)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// doWithRetry sends the request built by newRequest, retrying transient failures.
+// On success the response is returned with an unread body that the caller must close.
+// All attempts share the deadline of ctx, so retries count against PLUGIN_TIMEOUT.
+func (c *GeminiClient) doWithRetry(ctx context.Context, desc string, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	cfg := c.config
+	policy := NewRetryPolicy(cfg)
+	start := time.Now()
+
+	if c.clientErr != nil {
+		return nil, c.clientErr
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if cfg.Debug {
+			c.logger.Printf("[DEBUG] %s attempt %d/%d\n", desc, attempt, policy.MaxAttempts)
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		var retryDelay time.Duration
+		resp, err := c.httpClient().Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%s request failed: %w", desc, ctx.Err())
+			}
+			if errors.Is(err, ErrCassetteNotFound) {
+				return nil, fmt.Errorf("%s request failed: %w", desc, err)
+			}
+			lastErr = fmt.Errorf("%s request failed: %w", desc, err)
+
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+
+		default:
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if readErr != nil {
+				return nil, fmt.Errorf("failed to read response: %w", readErr)
+			}
+
+			if cfg.Debug {
+				c.logger.Printf("[DEBUG] Response status: %d\n", resp.StatusCode)
+				c.logger.Printf("[DEBUG] Response body: %s\n", string(body))
+			}
+
+			apiErr := newAPIStatusError(resp, body)
+			if !apiErr.Retryable() {
+				return nil, apiErr
+			}
+			lastErr = apiErr
+			retryDelay = apiErr.RetryDelay
+		}
+
+		if attempt == policy.MaxAttempts {
+			break
+		}
+
+		if retryDelay == 0 {
+			retryDelay = policy.Backoff(attempt)
+		}
+
+		// Don't start a retry that cannot finish before the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(retryDelay).After(deadline) {
+			c.logger.Printf("[RETRY] %s attempt %d/%d failed: %v (no time left to retry)\n", desc, attempt, policy.MaxAttempts, lastErr)
+			return nil, fmt.Errorf("%w after %d attempts in %s: %w", ErrRetriesExhausted, attempt, time.Since(start).Round(time.Millisecond), lastErr)
+		}
+
+		c.logger.Printf("[RETRY] %s attempt %d/%d failed: %v (retrying in %s)\n", desc, attempt, policy.MaxAttempts, lastErr, retryDelay.Round(time.Millisecond))
+
+		timer := time.NewTimer(retryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%s cancelled while waiting to retry: %w", desc, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	return nil, fmt.Errorf("%w after %d attempts in %s: %w", ErrRetriesExhausted, policy.MaxAttempts, time.Since(start).Round(time.Millisecond), lastErr)
+}
+
+// retryDelay extracts the delay from a google.rpc.RetryInfo error detail
+func (e *APIError) retryDelay() time.Duration {
+	for _, detail := range e.Details {
+		if !strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") || detail.RetryDelay == "" {
+			continue
+		}
+		// RetryInfo uses the protobuf Duration JSON format, e.g. "37s" or "0.5s"
+		if delay, err := time.ParseDuration(detail.RetryDelay); err == nil {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"0", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.input)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = (%v, %v), want (%v, %v)", tt.input, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestNewAPIStatusError_RetryInfo(t *testing.T) {
+	body := `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED",` +
+		`"details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"37s"}]}}`
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+
+	apiErr := newAPIStatusError(resp, []byte(body))
+	if apiErr.Status != "RESOURCE_EXHAUSTED" {
+		t.Errorf("Status = %q, want RESOURCE_EXHAUSTED", apiErr.Status)
+	}
+	if apiErr.RetryDelay != 37*time.Second {
+		t.Errorf("RetryDelay = %v, want 37s", apiErr.RetryDelay)
+	}
+	if !apiErr.Retryable() {
+		t.Error("429 should be retryable")
+	}
+
+	resp.Header.Set("Retry-After", "2")
+	apiErr = newAPIStatusError(resp, []byte(body))
+	if apiErr.RetryDelay != 2*time.Second {
+		t.Errorf("RetryDelay = %v, want Retry-After to take precedence", apiErr.RetryDelay)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		got := policy.Backoff(1)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("Backoff(1) with jitter = %v, want within 50ms-150ms", got)
+		}
+	}
+}
+
+// scriptedTransport returns the given status codes in order, then 200
+func scriptedTransport(calls *int, statuses ...int) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		status := http.StatusOK
+		if *calls < len(statuses) {
+			status = statuses[*calls]
+		}
+		*calls++
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+		}, nil
+	})
+}
+
+func TestDoWithRetry(t *testing.T) {
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", "http://fake.local/", nil)
+	}
+
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int
+		expectError   bool
+		exhausted     bool
+	}{
+		{
+			name:          "Succeeds after transient failures",
+			statuses:      []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+			expectedCalls: 3,
+		},
+		{
+			name:          "Permanent failure is not retried",
+			statuses:      []int{http.StatusForbidden},
+			expectedCalls: 1,
+			expectError:   true,
+		},
+		{
+			name:          "Gives up after max attempts",
+			statuses:      []int{500, 500, 500, 500},
+			expectedCalls: 3,
+			expectError:   true,
+			exhausted:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			cfg := Config{RetryMaxAttempts: 3, RetryBaseDelay: 1}
+			client := NewGeminiClient(&cfg, WithTransport(scriptedTransport(&calls, tt.statuses...)))
+
+			resp, err := client.doWithRetry(context.Background(), "test", newRequest)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if calls != tt.expectedCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.expectedCalls)
+			}
+			if tt.expectError != (err != nil) {
+				t.Fatalf("doWithRetry() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.exhausted != errors.Is(err, ErrRetriesExhausted) {
+				t.Errorf("doWithRetry() error = %v, exhausted %v", err, tt.exhausted)
+			}
+
+			var apiErr *APIStatusError
+			if tt.expectError && !errors.As(err, &apiErr) {
+				t.Errorf("doWithRetry() error = %v, want *APIStatusError", err)
+			}
+		})
+	}
+}
+
+func TestDoWithRetry_RespectsDeadline(t *testing.T) {
+	var calls int
+	cfg := Config{RetryMaxAttempts: 5, RetryBaseDelay: 10000}
+	client := NewGeminiClient(&cfg, WithTransport(scriptedTransport(&calls, 503, 503, 503)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := client.doWithRetry(ctx, "test", func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", "http://fake.local/", nil)
+	})
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Errorf("doWithRetry() error = %v, want ErrRetriesExhausted", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 (backoff exceeds deadline)", calls)
+	}
+}
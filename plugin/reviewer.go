@@ -0,0 +1,314 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reviewer runs code reviews with Gemini. It is the library entry point behind
+// the Drone plugin: build one with NewReviewer and call Review, or Start a
+// Session to generate the answer and follow-ups one at a time.
+type Reviewer struct {
+	config        Config
+	clientOptions []ClientOption
+	logger        Logger
+	tokens        TokenSource // shared by all sessions so access tokens are reused
+}
+
+// Option configures a Reviewer
+type Option func(*Reviewer)
+
+// WithConfig replaces the whole configuration, e.g. one loaded from PLUGIN_*
+// variables. Apply it before options that change single settings.
+func WithConfig(cfg Config) Option {
+	return func(r *Reviewer) {
+		r.config = cfg
+	}
+}
+
+// WithAPIKey authenticates with a Google AI Studio API key
+func WithAPIKey(key string) Option {
+	return func(r *Reviewer) {
+		r.config.APIKey = key
+	}
+}
+
+// WithVertexAI authenticates against Vertex AI with service account JSON
+func WithVertexAI(project, location, credentials string) Option {
+	return func(r *Reviewer) {
+		r.config.GCPProject = project
+		r.config.GCPLocation = location
+		r.config.GCPCredentials = credentials
+	}
+}
+
+// WithModel sets the model, or a fallback chain of models in order of preference
+func WithModel(models ...string) Option {
+	return func(r *Reviewer) {
+		r.config.Model = strings.Join(models, ",")
+	}
+}
+
+// WithTarget sets the file or directory to review
+func WithTarget(target string) Option {
+	return func(r *Reviewer) {
+		r.config.Target = target
+	}
+}
+
+// WithFollowUps sets prompts that Review sends after the first answer
+func WithFollowUps(prompts ...string) Option {
+	return func(r *Reviewer) {
+		r.config.FollowUps = prompts
+	}
+}
+
+// WithLogger sets where diagnostic messages are written (default: stdout).
+// Use NopLogger to discard them.
+func WithLogger(logger Logger) Option {
+	return func(r *Reviewer) {
+		r.logger = logger
+	}
+}
+
+// WithClientOptions passes options to the API client, e.g. WithTransport or WithOutput
+func WithClientOptions(opts ...ClientOption) Option {
+	return func(r *Reviewer) {
+		r.clientOptions = append(r.clientOptions, opts...)
+	}
+}
+
+// NewReviewer creates a Reviewer from DefaultConfig and opts.
+// Vertex AI credentials are looked up and the settings validated here;
+// the prompt is given per review.
+func NewReviewer(opts ...Option) (*Reviewer, error) {
+	return NewReviewerContext(context.Background(), opts...)
+}
+
+// NewReviewerContext is NewReviewer with a context for the credentials lookup,
+// which may query the metadata server
+func NewReviewerContext(ctx context.Context, opts ...Option) (*Reviewer, error) {
+	r := &Reviewer{
+		config: DefaultConfig(),
+		logger: defaultLogger,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	// Find Application Default Credentials and the project unless configured
+	if err := r.config.ResolveCredentials(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.config.validateSettings(); err != nil {
+		return nil, err
+	}
+	r.tokens = r.newClient(&r.config).tokens
+	return r, nil
+}
+
+// Config returns the configuration of the reviewer
+func (r *Reviewer) Config() Config {
+	return r.config
+}
+
+// Result is the outcome of a review
+type Result struct {
+	Text         string            // answer to the prompt
+	Thoughts     string            // thought summaries of the answer, if enabled
+	Structured   any               // parsed JSON answer, only with a response schema
+	Turns        []*GenerateResult // the answer followed by one result per follow-up
+	Usage        *UsageStats       // usage and cost summed over all calls, nil until a call returned usage
+	Files        []string          // files included in the code context, relative to the target
+	PromptTokens PromptTokens      // input size of the first request
+	Models       []ModelInfo       // the fallback chain as reported by the models API, if available
+	Timings      Timings
+}
+
+// Timings breaks down where a review spent its time
+type Timings struct {
+	ModelCheck   time.Duration // looking up the configured models
+	BuildContext time.Duration // reading the repository and git history
+	CountTokens  time.Duration // counting the prompt tokens
+	Generate     time.Duration // generating the answer and follow-ups
+	Total        time.Duration
+}
+
+// Review answers prompt and then the configured follow-ups. If a call fails or
+// ctx is cancelled, the partial result is returned with the error so the usage
+// consumed so far can still be reported.
+func (r *Reviewer) Review(ctx context.Context, prompt string) (*Result, error) {
+	session, err := r.Start(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := session.Generate(ctx); err != nil {
+		return session.Result(), err
+	}
+	for i, followUp := range r.config.FollowUps {
+		if _, err := session.FollowUp(ctx, followUp); err != nil {
+			return session.Result(), fmt.Errorf("follow-up %d: %w", i+1, err)
+		}
+	}
+	return session.Result(), nil
+}
+
+// ListModels returns the models the configured credentials can use
+func (r *Reviewer) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	client := r.newClient(&r.config)
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+	return client.ListModels(ctx)
+}
+
+// newClient creates an API client for cfg that logs through the reviewer and
+// shares its token cache
+func (r *Reviewer) newClient(cfg *Config) *GeminiClient {
+	opts := []ClientOption{withLogger(r.logger)}
+	if r.tokens != nil {
+		opts = append(opts, WithTokenSource(r.tokens))
+	}
+	return NewGeminiClient(cfg, append(opts, r.clientOptions...)...)
+}
+
+// Session is a review in progress: the code context is built and the prompt
+// tokens are counted, ready to generate the answer and ask follow-ups
+type Session struct {
+	config   Config
+	client   *GeminiClient
+	req      *GenerateContentRequest
+	last     *GenerateResult
+	started  time.Time
+	deadline time.Time // end of the PLUGIN_TIMEOUT budget, zero without a timeout
+	result   Result
+}
+
+// Start prepares a review of prompt: the configured models are checked before
+// the code context is built, then the prompt tokens are counted. The timeout
+// budget starts once the context is built and covers all later API calls.
+func (r *Reviewer) Start(ctx context.Context, prompt string) (*Session, error) {
+	if prompt == "" {
+		return nil, ErrPromptRequired
+	}
+
+	s := &Session{config: r.config, started: time.Now()}
+	s.config.Prompt = prompt
+	s.client = r.newClient(&s.config)
+
+	// Validate the models before building a possibly large context
+	start := time.Now()
+	checkCtx, cancel := s.client.withTimeout(ctx)
+	models, err := s.client.CheckModels(checkCtx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	s.result.Models = models
+	s.result.Timings.ModelCheck = time.Since(start)
+
+	start = time.Now()
+	s.req, err = s.client.BuildRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.result.Files = s.req.files
+	s.result.Timings.BuildContext = time.Since(start)
+
+	if s.config.Timeout > 0 {
+		s.deadline = time.Now().Add(time.Duration(s.config.Timeout) * time.Second)
+	}
+
+	start = time.Now()
+	countCtx, cancel := s.withDeadline(ctx)
+	defer cancel()
+	s.result.PromptTokens, err = s.client.CountTokens(countCtx, s.req)
+	if err != nil {
+		return nil, err
+	}
+	s.result.Timings.CountTokens = time.Since(start)
+	s.result.Timings.Total = time.Since(s.started)
+
+	return s, nil
+}
+
+// Generate produces the answer to the prompt. It must be called once, before any follow-up.
+func (s *Session) Generate(ctx context.Context) (*GenerateResult, error) {
+	if s.last != nil {
+		return nil, errors.New("answer already generated: use FollowUp to continue the conversation")
+	}
+	return s.generate(ctx, func(ctx context.Context) (*GenerateResult, error) {
+		return s.client.Generate(ctx, s.req)
+	})
+}
+
+// FollowUp asks prompt in the same conversation, reusing the code context
+func (s *Session) FollowUp(ctx context.Context, prompt string) (*GenerateResult, error) {
+	if s.last == nil {
+		return nil, errors.New("no answer to follow up on: call Generate first")
+	}
+	previous := s.last
+	return s.generate(ctx, func(ctx context.Context) (*GenerateResult, error) {
+		return s.client.FollowUp(ctx, s.req, previous, prompt)
+	})
+}
+
+// generate runs one turn within the session deadline and records its result and usage
+func (s *Session) generate(ctx context.Context, call func(context.Context) (*GenerateResult, error)) (*GenerateResult, error) {
+	ctx, cancel := s.withDeadline(ctx)
+	defer cancel()
+
+	start := time.Now()
+	result, err := call(ctx)
+	s.result.Timings.Generate += time.Since(start)
+	s.result.Timings.Total = time.Since(s.started)
+
+	// A cancelled call may still have consumed tokens
+	if result != nil {
+		s.addUsage(result.Usage)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if s.last == nil {
+		s.result.Text = result.Text
+		s.result.Thoughts = result.Thoughts
+		s.result.Structured = result.Structured
+	}
+	s.last = result
+	s.result.Turns = append(s.result.Turns, result)
+	return result, nil
+}
+
+// addUsage sums usage into the result; the first stats are copied so the
+// usage of each turn stays unchanged
+func (s *Session) addUsage(usage *UsageStats) {
+	if usage == nil {
+		return
+	}
+	if s.result.Usage == nil {
+		total := *usage
+		total.SkippedModels = append([]string(nil), usage.SkippedModels...)
+		s.result.Usage = &total
+		return
+	}
+	s.result.Usage.Add(usage)
+}
+
+// withDeadline applies the session's timeout budget to ctx
+func (s *Session) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, s.deadline)
+}
+
+// Result returns the review so far
+func (s *Session) Result() *Result {
+	result := s.result
+	return &result
+}
@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// captureLogger records log messages for assertions
+type captureLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *captureLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.messages, "\n")
+}
+
+func TestDefaultConfig_MatchesEnvDefaults(t *testing.T) {
+	var cfg Config
+	if err := envconfig.Process("plugin_defaults_test", &cfg); err != nil {
+		t.Fatalf("envconfig.Process() error: %v", err)
+	}
+	if got := DefaultConfig(); !reflect.DeepEqual(got, cfg) {
+		t.Errorf("DefaultConfig() = %+v\nwant envconfig defaults %+v", got, cfg)
+	}
+}
+
+func TestNewReviewer(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{
+			name: "api key",
+			opts: []Option{WithAPIKey("key")},
+		},
+		{
+			name: "vertex ai",
+			opts: []Option{WithVertexAI("project", "europe-west4", serviceAccountJSON(t, "project"))},
+		},
+		{
+			name:    "no credentials",
+			opts:    nil,
+			wantErr: ErrNoCredentials,
+		},
+		{
+			name:    "invalid setting",
+			opts:    []Option{WithAPIKey("key"), WithConfig(Config{APIKey: "key", MaxContinuations: -1})},
+			wantErr: ErrInvalidGenerationConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReviewer(tt.opts...)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("NewReviewer() unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewReviewer() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewReviewer_Options(t *testing.T) {
+	reviewer, err := NewReviewer(
+		WithAPIKey("key"),
+		WithModel("gemini-2.5-pro", "gemini-2.5-flash"),
+		WithTarget("src"),
+		WithFollowUps("Any tests missing?"),
+	)
+	if err != nil {
+		t.Fatalf("NewReviewer() unexpected error: %v", err)
+	}
+
+	cfg := reviewer.Config()
+	if cfg.Model != "gemini-2.5-pro,gemini-2.5-flash" {
+		t.Errorf("Model = %q, want the fallback chain", cfg.Model)
+	}
+	if cfg.Target != "src" {
+		t.Errorf("Target = %q, want src", cfg.Target)
+	}
+	if len(cfg.FollowUps) != 1 {
+		t.Errorf("FollowUps = %v, want one prompt", cfg.FollowUps)
+	}
+	if cfg.Timeout != 300 || cfg.MaxFiles != 50 {
+		t.Errorf("Timeout = %d, MaxFiles = %d, want the defaults", cfg.Timeout, cfg.MaxFiles)
+	}
+}
+
+func TestReviewer_Review(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(geminitest.MethodGenerateContent,
+		geminitest.TextResponse("Looks good", 100),
+		geminitest.TextResponse("No tests are missing", 150),
+	)
+
+	logger := &captureLogger{}
+	cfg := execConfig(t)
+	cfg.Debug = true
+	reviewer, err := NewReviewer(
+		WithConfig(cfg),
+		WithFollowUps("Any tests missing?"),
+		WithLogger(logger),
+		WithClientOptions(WithTransport(srv.Transport())),
+	)
+	if err != nil {
+		t.Fatalf("NewReviewer() unexpected error: %v", err)
+	}
+
+	result, err := reviewer.Review(context.Background(), "Review this code")
+	if err != nil {
+		t.Fatalf("Review() unexpected error: %v", err)
+	}
+
+	if result.Text != "Looks good" {
+		t.Errorf("Text = %q, want the first answer", result.Text)
+	}
+	if len(result.Turns) != 2 || result.Turns[1].Text != "No tests are missing" {
+		t.Errorf("Turns = %d, want the answer and the follow-up", len(result.Turns))
+	}
+	if !reflect.DeepEqual(result.Files, []string{"main.go"}) {
+		t.Errorf("Files = %v, want [main.go]", result.Files)
+	}
+	if result.PromptTokens.Count != 100 {
+		t.Errorf("PromptTokens.Count = %d, want 100", result.PromptTokens.Count)
+	}
+	if len(result.Models) != 1 || result.Models[0].ID != "gemini-2.5-flash" {
+		t.Errorf("Models = %+v, want gemini-2.5-flash", result.Models)
+	}
+	if result.Usage == nil || result.Usage.InputTokens != 250 {
+		t.Errorf("Usage = %+v, want input tokens of both turns", result.Usage)
+	}
+	if result.Turns[0].Usage.InputTokens != 100 {
+		t.Errorf("first turn input tokens = %d, want its own usage unchanged", result.Turns[0].Usage.InputTokens)
+	}
+	if result.Timings.Total <= 0 || result.Timings.Total < result.Timings.Generate {
+		t.Errorf("Timings = %+v, want a total covering generation", result.Timings)
+	}
+	if !strings.Contains(logger.String(), "[DEBUG]") {
+		t.Errorf("debug messages were not sent to the logger: %q", logger.String())
+	}
+}
+
+func TestSession_Order(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	reviewer, err := NewReviewer(
+		WithConfig(execConfig(t)),
+		WithLogger(NopLogger()),
+		WithClientOptions(WithTransport(srv.Transport())),
+	)
+	if err != nil {
+		t.Fatalf("NewReviewer() unexpected error: %v", err)
+	}
+
+	if _, err := reviewer.Start(context.Background(), ""); !errors.Is(err, ErrPromptRequired) {
+		t.Fatalf("Start() error = %v, want ErrPromptRequired", err)
+	}
+
+	session, err := reviewer.Start(context.Background(), "Review this code")
+	if err != nil {
+		t.Fatalf("Start() unexpected error: %v", err)
+	}
+	if _, err := session.FollowUp(context.Background(), "More?"); err == nil {
+		t.Error("FollowUp() before Generate should fail")
+	}
+	if session.Result().Usage != nil {
+		t.Error("Usage should be nil before the first answer")
+	}
+	if _, err := session.Generate(context.Background()); err != nil {
+		t.Fatalf("Generate() unexpected error: %v", err)
+	}
+	if _, err := session.Generate(context.Background()); err == nil {
+		t.Error("Generate() twice should fail")
+	}
+	if _, err := session.FollowUp(context.Background(), "More?"); err != nil {
+		t.Fatalf("FollowUp() unexpected error: %v", err)
+	}
+	if got := len(session.Result().Turns); got != 2 {
+		t.Errorf("Turns = %d, want 2", got)
+	}
+}
+
+func TestNewReviewerContext_Cancelled(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+	t.Setenv("GCE_METADATA_HOST", srv.MetadataHost())
+
+	cfg := execConfig(t)
+	cfg.APIKey = ""
+	cfg.APIEndpoint = srv.URL
+
+	// Cancellation stops the credentials lookup
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewReviewerContext(ctx, WithConfig(cfg)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("NewReviewerContext() error = %v, want context.Canceled", err)
+	}
+
+	// and does not leave the metadata server marked unavailable
+	reviewer, err := NewReviewerContext(context.Background(), WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("NewReviewerContext() unexpected error: %v", err)
+	}
+	resolved := reviewer.Config()
+	if source := resolved.CredentialsSource(); !strings.Contains(source, "metadata server") {
+		t.Errorf("CredentialsSource() = %q, want the metadata server", source)
+	}
+}
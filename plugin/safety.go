@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SafetySetting configures the blocking threshold for a harm category
+type SafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+// SafetyRating is the model's assessment of a harm category
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// PromptFeedback reports whether the prompt itself was blocked
+type PromptFeedback struct {
+	BlockReason        string         `json:"blockReason,omitempty"`
+	BlockReasonMessage string         `json:"blockReasonMessage,omitempty"`
+	SafetyRatings      []SafetyRating `json:"safetyRatings,omitempty"`
+}
+
+// harmCategories maps short setting names to API harm categories
+var harmCategories = map[string]string{
+	"harassment":        "HARM_CATEGORY_HARASSMENT",
+	"hate_speech":       "HARM_CATEGORY_HATE_SPEECH",
+	"sexually_explicit": "HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"dangerous_content": "HARM_CATEGORY_DANGEROUS_CONTENT",
+	"civic_integrity":   "HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// harmThresholds maps short setting names to API block thresholds
+var harmThresholds = map[string]string{
+	"none":             "BLOCK_NONE",
+	"only_high":        "BLOCK_ONLY_HIGH",
+	"medium_and_above": "BLOCK_MEDIUM_AND_ABOVE",
+	"low_and_above":    "BLOCK_LOW_AND_ABOVE",
+	"off":              "OFF",
+}
+
+// blockingFinishReasons are finish reasons where the candidate content was withheld
+var blockingFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+}
+
+// ParseSafetySettings parses "category=threshold" entries, e.g.
+// "dangerous_content=only_high" or "HARM_CATEGORY_HARASSMENT=BLOCK_NONE"
+func ParseSafetySettings(entries []string) ([]SafetySetting, error) {
+	var settings []SafetySetting
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		category, threshold, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q must be in category=threshold format", ErrInvalidSafetySettings, entry)
+		}
+
+		category = normalizeSafetyName(category, "HARM_CATEGORY_", harmCategories)
+		if !knownSafetyName(category, harmCategories) {
+			return nil, fmt.Errorf("%w: unknown harm category %q", ErrInvalidSafetySettings, category)
+		}
+
+		threshold = normalizeSafetyName(threshold, "BLOCK_", harmThresholds)
+		if !knownSafetyName(threshold, harmThresholds) {
+			return nil, fmt.Errorf("%w: unknown threshold %q", ErrInvalidSafetySettings, threshold)
+		}
+
+		settings = append(settings, SafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings, nil
+}
+
+// normalizeSafetyName maps a short name to its API value, leaving full API names unchanged
+func normalizeSafetyName(name, prefix string, shortNames map[string]string) string {
+	name = strings.TrimSpace(name)
+	if full, ok := shortNames[strings.ToLower(name)]; ok {
+		return full
+	}
+	upper := strings.ToUpper(name)
+	if strings.HasPrefix(upper, prefix) || upper == "OFF" {
+		return upper
+	}
+	return name
+}
+
+// knownSafetyName reports whether value is one of the API values in shortNames
+func knownSafetyName(value string, shortNames map[string]string) bool {
+	for _, full := range shortNames {
+		if full == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResponse turns blocked or empty responses into typed errors.
+// It returns a warning for responses that were cut short but still carry text.
+func checkResponse(apiResp *GenerateContentResponse) (warning string, err error) {
+	if feedback := apiResp.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+		return "", &PromptBlockedError{
+			Reason:  feedback.BlockReason,
+			Message: feedback.BlockReasonMessage,
+			Ratings: feedback.SafetyRatings,
+		}
+	}
+
+	if len(apiResp.Candidates) == 0 {
+		return "", ErrEmptyResponse
+	}
+
+	text, _ := extractText(apiResp)
+	hasCalls := len(functionCalls(modelTurn(apiResp))) > 0
+	for _, candidate := range apiResp.Candidates {
+		if blockingFinishReasons[candidate.FinishReason] {
+			return "", &CandidateStoppedError{
+				FinishReason: candidate.FinishReason,
+				Message:      candidate.FinishMessage,
+				Ratings:      candidate.SafetyRatings,
+			}
+		}
+
+		switch candidate.FinishReason {
+		case "", "STOP", "FINISH_REASON_UNSPECIFIED":
+		default:
+			if text == "" && !hasCalls {
+				return "", &CandidateStoppedError{
+					FinishReason: candidate.FinishReason,
+					Message:      candidate.FinishMessage,
+				}
+			}
+			warning = fmt.Sprintf("response may be incomplete: finish reason %s", candidate.FinishReason)
+		}
+	}
+
+	// A turn of only function calls is a valid answer in agent mode
+	if text == "" && !hasCalls {
+		return "", ErrEmptyResponse
+	}
+
+	return warning, nil
+}
+
+// formatRatings lists the harm categories that were blocked or rated above negligible
+func formatRatings(ratings []SafetyRating) string {
+	var flagged []string
+	for _, rating := range ratings {
+		if rating.Blocked || (rating.Probability != "" && rating.Probability != "NEGLIGIBLE") {
+			flagged = append(flagged, fmt.Sprintf("%s: %s", strings.TrimPrefix(rating.Category, "HARM_CATEGORY_"), rating.Probability))
+		}
+	}
+	return strings.Join(flagged, ", ")
+}
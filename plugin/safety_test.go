@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSafetySettings(t *testing.T) {
+	settings, err := ParseSafetySettings([]string{"dangerous_content=only_high", " HARM_CATEGORY_HARASSMENT=BLOCK_NONE ", "hate_speech=off"})
+	if err != nil {
+		t.Fatalf("ParseSafetySettings() unexpected error: %v", err)
+	}
+
+	expected := []SafetySetting{
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+		{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
+		{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "OFF"},
+	}
+	if len(settings) != len(expected) {
+		t.Fatalf("ParseSafetySettings() = %+v, want %+v", settings, expected)
+	}
+	for i := range expected {
+		if settings[i] != expected[i] {
+			t.Errorf("setting[%d] = %+v, want %+v", i, settings[i], expected[i])
+		}
+	}
+
+	for _, invalid := range []string{"dangerous_content", "violence=none", "harassment=sometimes"} {
+		if _, err := ParseSafetySettings([]string{invalid}); !errors.Is(err, ErrInvalidSafetySettings) {
+			t.Errorf("ParseSafetySettings(%q) error = %v, want ErrInvalidSafetySettings", invalid, err)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	textCandidate := func(text, finishReason string) Candidate {
+		return Candidate{
+			Content:      Content{Role: "model", Parts: []Part{{Text: text}}},
+			FinishReason: finishReason,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		response    GenerateContentResponse
+		expectError error
+		warning     bool
+	}{
+		{
+			name:     "Normal response",
+			response: GenerateContentResponse{Candidates: []Candidate{textCandidate("ok", "STOP")}},
+		},
+		{
+			name: "Prompt blocked",
+			response: GenerateContentResponse{PromptFeedback: &PromptFeedback{
+				BlockReason:   "SAFETY",
+				SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "HIGH", Blocked: true}},
+			}},
+			expectError: &PromptBlockedError{},
+		},
+		{
+			name: "Candidate stopped for safety",
+			response: GenerateContentResponse{Candidates: []Candidate{{
+				FinishReason:  "SAFETY",
+				SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_HARASSMENT", Probability: "MEDIUM", Blocked: true}},
+			}}},
+			expectError: &CandidateStoppedError{},
+		},
+		{
+			name:        "Recitation with partial text",
+			response:    GenerateContentResponse{Candidates: []Candidate{textCandidate("partial", "RECITATION")}},
+			expectError: &CandidateStoppedError{},
+		},
+		{
+			name:        "Max tokens without text",
+			response:    GenerateContentResponse{Candidates: []Candidate{{FinishReason: "MAX_TOKENS"}}},
+			expectError: &CandidateStoppedError{},
+		},
+		{
+			name:     "Max tokens with text",
+			response: GenerateContentResponse{Candidates: []Candidate{textCandidate("partial", "MAX_TOKENS")}},
+			warning:  true,
+		},
+		{
+			name:        "No candidates",
+			response:    GenerateContentResponse{},
+			expectError: ErrEmptyResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning, err := checkResponse(&tt.response)
+
+			switch expected := tt.expectError.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("checkResponse() unexpected error: %v", err)
+				}
+			case *PromptBlockedError:
+				if !errors.As(err, &expected) {
+					t.Errorf("checkResponse() error = %v, want *PromptBlockedError", err)
+				}
+			case *CandidateStoppedError:
+				if !errors.As(err, &expected) {
+					t.Errorf("checkResponse() error = %v, want *CandidateStoppedError", err)
+				}
+			default:
+				if err != tt.expectError {
+					t.Errorf("checkResponse() error = %v, want %v", err, tt.expectError)
+				}
+			}
+
+			if tt.warning != (warning != "") {
+				t.Errorf("checkResponse() warning = %q, want warning %v", warning, tt.warning)
+			}
+		})
+	}
+}
+
+func TestPromptBlockedError_Message(t *testing.T) {
+	err := &PromptBlockedError{
+		Reason:  "SAFETY",
+		Ratings: []SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "HIGH", Blocked: true}},
+	}
+	if msg := err.Error(); !strings.Contains(msg, "SAFETY") || !strings.Contains(msg, "DANGEROUS_CONTENT: HIGH") {
+		t.Errorf("Error() = %q, want reason and flagged category", msg)
+	}
+}
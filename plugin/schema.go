@@ -0,0 +1,274 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FindingsSchemaName selects the built-in code review findings schema
+const FindingsSchemaName = "findings"
+
+// findingsSchema is the built-in JSON Schema for code review results
+const findingsSchema = `{
+  "type": "object",
+  "properties": {
+    "summary": {
+      "type": "string",
+      "description": "One paragraph summary of the review"
+    },
+    "findings": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "severity": {"type": "string", "enum": ["critical", "high", "medium", "low", "info"]},
+          "category": {"type": "string", "description": "e.g. security, bug, performance, style"},
+          "file": {"type": "string"},
+          "line": {"type": "integer", "minimum": 0},
+          "title": {"type": "string"},
+          "description": {"type": "string"},
+          "suggestion": {"type": "string"}
+        },
+        "required": ["severity", "title", "description"]
+      }
+    }
+  },
+  "required": ["summary", "findings"]
+}`
+
+// LoadResponseSchema returns the configured response JSON Schema: the built-in
+// findings schema, or the content of a JSON Schema file. Returns nil when unset.
+func (c *Config) LoadResponseSchema() (json.RawMessage, error) {
+	if c.ResponseSchema == "" {
+		return nil, nil
+	}
+
+	data := []byte(findingsSchema)
+	if c.ResponseSchema != FindingsSchemaName {
+		var err error
+		data, err = os.ReadFile(c.ResponseSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResponseSchema, err)
+		}
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("%w: %s is not valid JSON: %v", ErrInvalidResponseSchema, c.ResponseSchema, err)
+	}
+
+	// Compact the schema to keep the request small
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponseSchema, err)
+	}
+	return compact.Bytes(), nil
+}
+
+// parseStructuredOutput parses the model's JSON answer and validates it against schema
+func parseStructuredOutput(schema json.RawMessage, text string) (any, error) {
+	text = strings.TrimSpace(text)
+
+	// Tolerate a Markdown code fence around the document
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	var doc any
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %v", err)
+	}
+
+	var schemaDoc map[string]any
+	if err := json.Unmarshal(schema, &schemaDoc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponseSchema, err)
+	}
+
+	if err := validateJSON(schemaDoc, doc, "$"); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// validateJSON checks value against the commonly used subset of JSON Schema:
+// type, enum, properties, required, additionalProperties, items and the
+// numeric, string length and array size bounds
+func validateJSON(schema map[string]any, value any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if jsonEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateObject(schema, v, path)
+
+	case []any:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, min, len(v))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, max, len(v))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+		length := float64(len([]rune(v)))
+		if min, ok := schema["minLength"].(float64); ok && length < min {
+			return fmt.Errorf("%s: expected at least %v characters", path, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && length > max {
+			return fmt.Errorf("%s: expected at most %v characters", path, max)
+		}
+
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, v, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, v, max)
+		}
+	}
+
+	return nil
+}
+
+// validateObject checks required, properties and additionalProperties
+func validateObject(schema map[string]any, obj map[string]any, path string) error {
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			key, _ := name.(string)
+			if _, present := obj[key]; !present {
+				return fmt.Errorf("%s: missing required property %q", path, key)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+
+	// Validate in a stable order so error messages are reproducible
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propSchema, known := properties[key].(map[string]any)
+		if !known {
+			if allowed, ok := schema["additionalProperties"].(bool); ok && !allowed {
+				return fmt.Errorf("%s: unexpected property %q", path, key)
+			}
+			continue
+		}
+		if err := validateJSON(propSchema, obj[key], path+"."+key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schemaTypes normalizes the "type" keyword, which may be a string or a list
+func schemaTypes(raw any) []string {
+	switch t := raw.(type) {
+	case string:
+		return []string{strings.ToLower(t)}
+	case []any:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, strings.ToLower(s))
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// jsonTypeMatches reports whether a decoded JSON value has the given schema type
+func jsonTypeMatches(schemaType string, value any) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// jsonTypeName returns the JSON type name of a decoded value for error messages
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b any) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseStructuredOutput(t *testing.T) {
+	cfg := Config{ResponseSchema: FindingsSchemaName}
+	schema, err := cfg.LoadResponseSchema()
+	if err != nil {
+		t.Fatalf("LoadResponseSchema() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		text        string
+		expectError string
+	}{
+		{
+			name: "Valid findings",
+			text: `{"summary":"ok","findings":[{"severity":"high","title":"SQL injection","description":"query built with fmt","line":42}]}`,
+		},
+		{
+			name: "Code fence is tolerated",
+			text: "```json\n{\"summary\":\"ok\",\"findings\":[]}\n```",
+		},
+		{
+			name:        "Not JSON",
+			text:        "Looks good to me!",
+			expectError: "not valid JSON",
+		},
+		{
+			name:        "Missing required property",
+			text:        `{"summary":"ok"}`,
+			expectError: `$: missing required property "findings"`,
+		},
+		{
+			name:        "Enum mismatch",
+			text:        `{"summary":"ok","findings":[{"severity":"urgent","title":"t","description":"d"}]}`,
+			expectError: "$.findings[0].severity",
+		},
+		{
+			name:        "Wrong type",
+			text:        `{"summary":"ok","findings":[{"severity":"low","title":"t","description":"d","line":1.5}]}`,
+			expectError: "$.findings[0].line: expected integer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseStructuredOutput(schema, tt.text)
+			if tt.expectError == "" {
+				if err != nil {
+					t.Errorf("parseStructuredOutput() unexpected error: %v", err)
+				}
+				if doc == nil {
+					t.Error("parseStructuredOutput() returned nil document")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("parseStructuredOutput() error = %v, want %q", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestValidateJSON_Bounds(t *testing.T) {
+	var schema map[string]any
+	_ = json.Unmarshal([]byte(`{"type":"object","additionalProperties":false,"properties":{
+		"tags":{"type":"array","maxItems":2,"items":{"type":"string","minLength":2}},
+		"score":{"type":["number","null"],"minimum":0,"maximum":10}}}`), &schema)
+
+	valid := []string{`{"tags":["go","ci"],"score":7}`, `{"score":null}`, `{}`}
+	for _, text := range valid {
+		var doc any
+		_ = json.Unmarshal([]byte(text), &doc)
+		if err := validateJSON(schema, doc, "$"); err != nil {
+			t.Errorf("validateJSON(%s) unexpected error: %v", text, err)
+		}
+	}
+
+	invalid := []string{`{"tags":["go","ci","cd"]}`, `{"tags":["x"]}`, `{"score":11}`, `{"extra":true}`}
+	for _, text := range invalid {
+		var doc any
+		_ = json.Unmarshal([]byte(text), &doc)
+		if err := validateJSON(schema, doc, "$"); err == nil {
+			t.Errorf("validateJSON(%s) expected error", text)
+		}
+	}
+}
+
+func TestGeminiClient_SchemaRetry(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"main.go": "package main\n"})
+
+	responses := []string{
+		`{"summary":"missing findings"}`,
+		`{"summary":"fixed","findings":[]}`,
+	}
+	var bodies []GenerateContentRequest
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body GenerateContentRequest
+		data, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(data, &body)
+		bodies = append(bodies, body)
+
+		text, _ := json.Marshal(responses[len(bodies)-1])
+		resp := `{"candidates":[{"content":{"role":"model","parts":[{"text":` + string(text) + `}]},"finishReason":"STOP"}],` +
+			`"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":10,"totalTokenCount":110}}`
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(resp))}, nil
+	})
+
+	cfg := Config{
+		Prompt:         "Review",
+		Target:         dir,
+		Model:          "gemini-2.5-flash",
+		APIKey:         "test-key",
+		APIEndpoint:    "http://fake.local",
+		ResponseSchema: FindingsSchemaName,
+	}
+
+	result, err := NewGeminiClient(&cfg, WithTransport(transport)).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("requests = %d, want 2 (one schema retry)", len(bodies))
+	}
+	if gen := bodies[0].GenerationConfig; gen == nil || gen.ResponseMimeType != "application/json" || len(gen.ResponseJSONSchema) == 0 {
+		t.Errorf("generationConfig = %+v, want application/json with schema", gen)
+	}
+	if contents := bodies[1].Contents; len(contents) != 3 || !strings.Contains(contents[2].Parts[0].Text, "missing required property") {
+		t.Errorf("retry contents = %+v, want invalid answer and validation error", contents)
+	}
+
+	doc, ok := result.Structured.(map[string]any)
+	if !ok || doc["summary"] != "fixed" {
+		t.Errorf("Structured = %v, want corrected document", result.Structured)
+	}
+	if result.Usage.InputTokens != 200 {
+		t.Errorf("Usage.InputTokens = %d, want usage of both calls", result.Usage.InputTokens)
+	}
+}
+
+func TestConfig_ValidateResponseSchema(t *testing.T) {
+	cfg := Config{Prompt: "Review", APIKey: "test-key", ResponseSchema: FindingsSchemaName, ResponseMimeType: "text/plain"}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidResponseSchema) {
+		t.Errorf("Validate() error = %v, want ErrInvalidResponseSchema", err)
+	}
+
+	cfg = Config{ResponseSchema: "does-not-exist.json"}
+	if _, err := cfg.LoadResponseSchema(); !errors.Is(err, ErrInvalidResponseSchema) {
+		t.Errorf("LoadResponseSchema() error = %v, want ErrInvalidResponseSchema", err)
+	}
+}
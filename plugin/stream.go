@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// readStream parses a server-sent events stream of GenerateContentResponse chunks
+// from streamGenerateContent?alt=sse. onChunk is called for every chunk as it
+// arrives; the returned response aggregates all chunks, with text parts of each
+// candidate concatenated and UsageMetadata taken from the last chunk carrying it.
+func readStream(r io.Reader, onChunk func(*GenerateContentResponse)) (*GenerateContentResponse, error) {
+	reader := bufio.NewReader(r)
+	aggregated := &GenerateContentResponse{}
+
+	var data strings.Builder
+	flush := func() error {
+		if data.Len() == 0 {
+			return nil
+		}
+		payload := data.String()
+		data.Reset()
+
+		var chunk GenerateContentResponse
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+
+		if onChunk != nil {
+			onChunk(&chunk)
+		}
+		aggregated.merge(&chunk)
+		return nil
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return aggregated, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			// A blank line terminates an event
+			if flushErr := flush(); flushErr != nil {
+				return aggregated, flushErr
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Other SSE fields (event, id, retry) and comments are not used by the API
+
+		if errors.Is(err, io.EOF) {
+			return aggregated, flush()
+		}
+	}
+}
+
+// merge folds a streamed chunk into the aggregated response
+func (r *GenerateContentResponse) merge(chunk *GenerateContentResponse) {
+	for _, candidate := range chunk.Candidates {
+		for len(r.Candidates) <= candidate.Index {
+			r.Candidates = append(r.Candidates, Candidate{Index: len(r.Candidates)})
+		}
+		target := &r.Candidates[candidate.Index]
+
+		if candidate.Content.Role != "" {
+			target.Content.Role = candidate.Content.Role
+		}
+		if candidate.FinishReason != "" {
+			target.FinishReason = candidate.FinishReason
+			target.FinishMessage = candidate.FinishMessage
+		}
+		if len(candidate.SafetyRatings) > 0 {
+			target.SafetyRatings = candidate.SafetyRatings
+		}
+		if candidate.URLContextMetadata != nil {
+			target.URLContextMetadata = candidate.URLContextMetadata
+		}
+		for _, part := range candidate.Content.Parts {
+			target.Content.appendPart(part)
+		}
+	}
+
+	if chunk.PromptFeedback != nil {
+		r.PromptFeedback = chunk.PromptFeedback
+	}
+	if chunk.UsageMetadata != nil {
+		r.UsageMetadata = chunk.UsageMetadata
+	}
+}
+
+// appendPart adds a part, joining consecutive text parts into one
+func (c *Content) appendPart(part Part) {
+	if n := len(c.Parts); n > 0 && part.isPlainText() && part.ThoughtSignature == "" &&
+		c.Parts[n-1].isPlainText() && part.Thought == c.Parts[n-1].Thought {
+		c.Parts[n-1].Text += part.Text
+		return
+	}
+	c.Parts = append(c.Parts, part)
+}
+
+// isPlainText reports whether the part carries only text
+func (p Part) isPlainText() bool {
+	return p.Text != "" && p.FileData == nil && p.FunctionCall == nil && p.FunctionResponse == nil &&
+		p.ExecutableCode == nil && p.CodeExecutionResult == nil
+}
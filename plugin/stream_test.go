@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testStream = "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hello\"}]}}]}\r\n\r\n" +
+	"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\", world\"}]}}]}\n\n" +
+	": keep-alive comment\n\n" +
+	"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"!\"}]}}]," +
+	"\"usageMetadata\":{\"promptTokenCount\":7,\"candidatesTokenCount\":3,\"totalTokenCount\":10}}\n"
+
+func TestReadStream(t *testing.T) {
+	var chunks []string
+	resp, err := readStream(strings.NewReader(testStream), func(chunk *GenerateContentResponse) {
+		chunks = append(chunks, chunk.Candidates[0].Content.Parts[0].Text)
+	})
+	if err != nil {
+		t.Fatalf("readStream() unexpected error: %v", err)
+	}
+
+	if len(chunks) != 3 {
+		t.Errorf("chunks = %q, want 3 chunks", chunks)
+	}
+	if len(resp.Candidates) != 1 || len(resp.Candidates[0].Content.Parts) != 1 {
+		t.Fatalf("aggregated candidates = %+v, want one candidate with one text part", resp.Candidates)
+	}
+	if got := resp.Candidates[0].Content.Parts[0].Text; got != "Hello, world!" {
+		t.Errorf("aggregated text = %q, want %q", got, "Hello, world!")
+	}
+	if resp.UsageMetadata == nil || resp.UsageMetadata.PromptTokenCount != 7 {
+		t.Errorf("UsageMetadata = %+v, want usage from last chunk", resp.UsageMetadata)
+	}
+}
+
+func TestReadStream_Error(t *testing.T) {
+	stream := "data: {\"error\":{\"code\":500,\"message\":\"internal\",\"status\":\"INTERNAL\"}}\n\n"
+	if _, err := readStream(strings.NewReader(stream), nil); err == nil {
+		t.Error("readStream() expected error for error chunk")
+	}
+}
+
+func TestGeminiClient_Stream(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotURL string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotURL = req.URL.String()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(testStream)),
+		}, nil
+	})
+
+	cfg := Config{
+		Prompt:      "Review",
+		Target:      dir,
+		Model:       "gemini-2.5-flash",
+		APIKey:      "test-key",
+		APIEndpoint: "http://fake.local",
+		Stream:      true,
+	}
+
+	var out bytes.Buffer
+	result, err := NewGeminiClient(&cfg, WithTransport(transport), WithOutput(&out)).GenerateContent(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+	if !strings.Contains(gotURL, ":streamGenerateContent?alt=sse") {
+		t.Errorf("request URL = %q, want streamGenerateContent with alt=sse", gotURL)
+	}
+	if out.String() != "Hello, world!" || result.Text != "Hello, world!" {
+		t.Errorf("streamed = %q, text = %q, want %q", out.String(), result.Text, "Hello, world!")
+	}
+	if result.Usage == nil || result.Usage.OutputTokens != 3 {
+		t.Errorf("usage = %+v, want 3 output tokens", result.Usage)
+	}
+}
+
+// blockingBody yields the first chunk, then blocks until the request is cancelled
+type blockingBody struct {
+	ctx   context.Context
+	chunk *strings.Reader
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	if b.chunk.Len() > 0 {
+		return b.chunk.Read(p)
+	}
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func (b *blockingBody) Close() error { return nil }
+
+func TestGeminiClient_StreamCancelled(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"main.go": "package main\n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		chunk := "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"partial\"}]}}]," +
+			"\"usageMetadata\":{\"promptTokenCount\":50,\"candidatesTokenCount\":5,\"totalTokenCount\":55}}\n\n"
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       &blockingBody{ctx: req.Context(), chunk: strings.NewReader(chunk)},
+		}, nil
+	})
+
+	cfg := Config{
+		Prompt:      "Review",
+		Target:      dir,
+		Model:       "gemini-2.5-flash",
+		APIKey:      "test-key",
+		APIEndpoint: "http://fake.local",
+		Stream:      true,
+	}
+
+	// Cancel as soon as the first chunk has been printed
+	out := writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return len(p), nil
+	})
+
+	result, err := NewGeminiClient(&cfg, WithTransport(transport), WithOutput(out)).GenerateContent(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("GenerateContent() error = %v, want context.Canceled", err)
+	}
+	if result == nil || result.Usage == nil || result.Usage.InputTokens != 50 {
+		t.Errorf("GenerateContent() result = %+v, want partial usage", result)
+	}
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
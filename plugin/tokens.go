@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PromptTokens is the input size of a request before it is sent
+type PromptTokens struct {
+	Count int
+	Exact bool // counted by the countTokens API rather than estimated locally
+}
+
+// countTokensRequest is the countTokens body for Google AI Studio and the
+// global endpoint, which wrap the complete generateContent request
+type countTokensRequest struct {
+	GenerateContentRequest *modelRequest `json:"generateContentRequest"`
+}
+
+// modelRequest is a generateContent request that names its model
+type modelRequest struct {
+	Model string `json:"model"`
+	*GenerateContentRequest
+}
+
+// vertexCountTokensRequest is the countTokens body for regional Vertex AI endpoints
+type vertexCountTokensRequest struct {
+	Contents          []Content         `json:"contents"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []Tool            `json:"tools,omitempty"`
+}
+
+// countTokensResponse is the countTokens response (both APIs)
+type countTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// CountTokens returns the prompt token count of req for the primary model.
+// The count is exact when the countTokens endpoint answers; otherwise it falls
+// back to the local estimate. Only a cancelled ctx is returned as an error.
+// The result is also used as the input estimate of the following Generate call.
+func (c *GeminiClient) CountTokens(ctx context.Context, req *GenerateContentRequest) (PromptTokens, error) {
+	cfg := c.config
+	model := cfg.PrimaryModel()
+
+	count, err := c.countTokens(ctx, model, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return PromptTokens{}, err
+		}
+
+		c.logger.Printf("[WARN] countTokens unavailable, using local estimate: %v\n", err)
+		tokens := PromptTokens{Count: NewCostCalculator(model).EstimateTokens(req.text())}
+		req.promptTokens = &tokens
+		return tokens, nil
+	}
+
+	if cfg.Debug {
+		c.logger.Printf("[DEBUG] countTokens: %d prompt tokens\n", count)
+	}
+
+	tokens := PromptTokens{Count: count, Exact: true}
+	req.promptTokens = &tokens
+	return tokens, nil
+}
+
+// countTokens calls the countTokens method of model
+func (c *GeminiClient) countTokens(ctx context.Context, model string, req *GenerateContentRequest) (int, error) {
+	cfg := c.config
+
+	// Regional Vertex AI takes the request fields inline; the generativelanguage
+	// API (AI Studio, global endpoint) wraps the full request with its model name
+	var body any
+	if cfg.DetectAuthMode() == AuthModeVertexAI && cfg.GCPLocation != "global" {
+		body = vertexCountTokensRequest{
+			Contents:          req.Contents,
+			SystemInstruction: req.SystemInstruction,
+			GenerationConfig:  req.GenerationConfig,
+			Tools:             req.Tools,
+		}
+	} else {
+		body = countTokensRequest{
+			GenerateContentRequest: &modelRequest{Model: "models/" + model, GenerateContentRequest: req},
+		}
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal countTokens request: %w", err)
+	}
+
+	resp, err := c.postModel(ctx, model, "countTokens", jsonBody)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read countTokens response: %w", err)
+	}
+
+	var countResp countTokensResponse
+	if err := json.Unmarshal(data, &countResp); err != nil {
+		return 0, fmt.Errorf("failed to parse countTokens response: %w", err)
+	}
+	return countResp.TotalTokens, nil
+}
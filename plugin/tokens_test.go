@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGeminiClient_CountTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		path    string
+		wrapped bool
+	}{
+		{
+			name:    "Google AI Studio",
+			config:  Config{APIKey: "test-key", Model: "gemini-2.5-pro,gemini-2.5-flash"},
+			path:    "/v1beta/models/gemini-2.5-pro:countTokens",
+			wrapped: true,
+		},
+		{
+			name: "Vertex AI regional",
+			config: Config{
+				GCPCredentials: serviceAccountJSON(t, "my-project"),
+				GCPProject:     "my-project",
+				GCPLocation:    "europe-west4",
+				Model:          "gemini-2.5-pro",
+			},
+			path: "/v1/projects/my-project/locations/europe-west4/publishers/google/models/gemini-2.5-pro:countTokens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			var body map[string]json.RawMessage
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				response := `{"totalTokens":1234}`
+				if req.URL.Host == "oauth2.googleapis.com" {
+					response = `{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`
+				} else {
+					path = req.URL.Path
+					data, _ := io.ReadAll(req.Body)
+					_ = json.Unmarshal(data, &body)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(response)),
+				}, nil
+			})
+
+			req := &GenerateContentRequest{
+				Contents:          []Content{{Role: "user", Parts: []Part{{Text: "Review"}}}},
+				SystemInstruction: &Content{Parts: []Part{{Text: "Be strict"}}},
+			}
+			tokens, err := NewGeminiClient(&tt.config, WithTransport(transport)).CountTokens(context.Background(), req)
+			if err != nil {
+				t.Fatalf("CountTokens() unexpected error: %v", err)
+			}
+			if tokens != (PromptTokens{Count: 1234, Exact: true}) {
+				t.Errorf("CountTokens() = %+v, want exact count 1234", tokens)
+			}
+			if path != tt.path {
+				t.Errorf("path = %q, want %q", path, tt.path)
+			}
+
+			_, wrapped := body["generateContentRequest"]
+			if wrapped != tt.wrapped {
+				t.Fatalf("body = %v, want generateContentRequest wrapper %v", body, tt.wrapped)
+			}
+			if tt.wrapped {
+				var inner map[string]json.RawMessage
+				_ = json.Unmarshal(body["generateContentRequest"], &inner)
+				if string(inner["model"]) != `"models/gemini-2.5-pro"` || inner["systemInstruction"] == nil {
+					t.Errorf("generateContentRequest = %v, want model and systemInstruction", inner)
+				}
+			} else if body["contents"] == nil || body["systemInstruction"] == nil {
+				t.Errorf("body = %v, want inline contents and systemInstruction", body)
+			}
+		})
+	}
+}
+
+func TestGeminiClient_CountTokensFallback(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, ":countTokens") {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"error":{"code":404,"message":"not found"}}`)),
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(okResponse)),
+		}, nil
+	})
+
+	cfg := Config{APIKey: "test-key", Model: "gemini-2.5-pro"}
+	client := NewGeminiClient(&cfg, WithTransport(transport))
+	req := &GenerateContentRequest{Contents: []Content{{Role: "user", Parts: []Part{{Text: strings.Repeat("x", 300)}}}}}
+
+	tokens, err := client.CountTokens(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CountTokens() unexpected error: %v", err)
+	}
+	if tokens != (PromptTokens{Count: 100}) {
+		t.Errorf("CountTokens() = %+v, want heuristic estimate of 100", tokens)
+	}
+
+	result, err := client.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate() unexpected error: %v", err)
+	}
+	if result.Usage.EstimatedInput != 100 {
+		t.Errorf("EstimatedInput = %d, want the CountTokens result", result.Usage.EstimatedInput)
+	}
+}
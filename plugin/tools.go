@@ -0,0 +1,480 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxToolOutput caps the text returned by a single tool call
+	maxToolOutput = 64 * 1024
+
+	// maxGrepMatches caps the matches returned by grep
+	maxGrepMatches = 200
+)
+
+// Tool declares capabilities the model may use during generation:
+// functions run by the plugin or a built-in tool run by the API
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+	CodeExecution        *CodeExecution        `json:"codeExecution,omitempty"`
+	URLContext           *URLContext           `json:"urlContext,omitempty"`
+}
+
+// CodeExecution enables the built-in tool that runs model-generated Python
+type CodeExecution struct{}
+
+// URLContext enables the built-in tool that retrieves URLs for the model
+type URLContext struct{}
+
+// ExecutableCode is code generated by the model for the code execution tool
+type ExecutableCode struct {
+	Language string `json:"language"` // e.g. PYTHON
+	Code     string `json:"code"`
+}
+
+// CodeExecutionResult is the result of running ExecutableCode
+type CodeExecutionResult struct {
+	Outcome string `json:"outcome"` // e.g. OUTCOME_OK, OUTCOME_FAILED, OUTCOME_DEADLINE_EXCEEDED
+	Output  string `json:"output,omitempty"`
+}
+
+// URLContextMetadata reports the URLs retrieved by the URL context tool
+type URLContextMetadata struct {
+	URLMetadata []URLMetadata `json:"urlMetadata,omitempty"`
+}
+
+// URLMetadata is the retrieval status of one URL
+type URLMetadata struct {
+	RetrievedURL       string `json:"retrievedUrl"`
+	URLRetrievalStatus string `json:"urlRetrievalStatus"` // e.g. URL_RETRIEVAL_STATUS_SUCCESS
+}
+
+// builtinToolNames lists the enabled built-in tools for the configuration summary
+func builtinToolNames(cfg *Config) []string {
+	var names []string
+	if cfg.CodeExecution {
+		names = append(names, "code execution")
+	}
+	if cfg.URLContext {
+		names = append(names, "URL context")
+	}
+	return names
+}
+
+// builtinTools returns the built-in tools enabled in the configuration
+func builtinTools(cfg *Config) []Tool {
+	var tools []Tool
+	if cfg.CodeExecution {
+		tools = append(tools, Tool{CodeExecution: &CodeExecution{}})
+	}
+	if cfg.URLContext {
+		tools = append(tools, Tool{URLContext: &URLContext{}})
+	}
+	return tools
+}
+
+// FunctionDeclaration describes a function the model can call
+type FunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolConfig controls how the model uses the declared functions
+type ToolConfig struct {
+	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+// FunctionCallingConfig sets the function calling mode: AUTO, ANY or NONE
+type FunctionCallingConfig struct {
+	Mode string `json:"mode"`
+}
+
+// FunctionCall is a function call requested by the model
+type FunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// FunctionResponse returns the result of a function call to the model
+type FunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// workspaceTools are the read-only functions offered to the model in agent mode
+var workspaceTools = []FunctionDeclaration{
+	{
+		Name:        "read_file",
+		Description: "Read a text file from the repository. Paths are relative to the repository root. Optionally limit the result to a line range.",
+		Parameters: json.RawMessage(`{"type":"OBJECT","properties":{` +
+			`"path":{"type":"STRING","description":"File path relative to the repository root"},` +
+			`"start_line":{"type":"INTEGER","description":"First line to return (1-based)"},` +
+			`"end_line":{"type":"INTEGER","description":"Last line to return (inclusive)"}},` +
+			`"required":["path"]}`),
+	},
+	{
+		Name:        "list_directory",
+		Description: "List the files and subdirectories of a repository directory. Directories end with a slash.",
+		Parameters: json.RawMessage(`{"type":"OBJECT","properties":{` +
+			`"path":{"type":"STRING","description":"Directory path relative to the repository root, default \".\""}}}`),
+	},
+	{
+		Name:        "grep",
+		Description: "Search repository files for a regular expression (RE2 syntax). Returns matching lines as path:line: text.",
+		Parameters: json.RawMessage(`{"type":"OBJECT","properties":{` +
+			`"pattern":{"type":"STRING","description":"Regular expression to search for"},` +
+			`"path":{"type":"STRING","description":"File or directory to search, default \".\""}},` +
+			`"required":["pattern"]}`),
+	},
+	{
+		Name:        "git_show",
+		Description: "Show a git commit (message and diff), optionally limited to one path. Use HEAD for the current commit.",
+		Parameters: json.RawMessage(`{"type":"OBJECT","properties":{` +
+			`"revision":{"type":"STRING","description":"Commit, branch or tag, e.g. HEAD or HEAD~1"},` +
+			`"path":{"type":"STRING","description":"Limit the diff to this path"}},` +
+			`"required":["revision"]}`),
+	},
+}
+
+// Workspace runs read-only tool calls sandboxed to a directory or a single file
+type Workspace struct {
+	root       string
+	file       string // the only file the tools may reach when the target is a file
+	debug      bool
+	logger     Logger
+	tokenCache string // PLUGIN_TOKEN_CACHE_FILE, never returned to the model
+}
+
+// NewWorkspace creates a workspace for target. For a directory the tools may use
+// everything in it; for a file, paths are relative to its directory but only the
+// file itself is reachable.
+func NewWorkspace(target string, debug bool) (*Workspace, error) {
+	root, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace %s: %w", target, err)
+	}
+	w := &Workspace{root: root, debug: debug, logger: defaultLogger}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		w.root, w.file = filepath.Dir(root), root
+	}
+	return w, nil
+}
+
+// Call executes a function call and returns its response for the model.
+// Tool failures are reported to the model rather than returned as errors.
+func (w *Workspace) Call(ctx context.Context, call FunctionCall) FunctionResponse {
+	output, err := w.run(ctx, call)
+
+	resp := FunctionResponse{ID: call.ID, Name: call.Name}
+	if err != nil {
+		w.logger.Printf("[TOOL] %s(%s) failed: %v\n", call.Name, formatArgs(call.Args), err)
+		resp.Response = map[string]any{"error": err.Error()}
+		return resp
+	}
+
+	truncated := false
+	if len(output) > maxToolOutput {
+		output = output[:maxToolOutput]
+		truncated = true
+	}
+	w.logger.Printf("[TOOL] %s(%s) -> %d bytes\n", call.Name, formatArgs(call.Args), len(output))
+
+	resp.Response = map[string]any{"output": output}
+	if truncated {
+		resp.Response["truncated"] = true
+	}
+	return resp
+}
+
+// run dispatches a function call to its tool
+func (w *Workspace) run(ctx context.Context, call FunctionCall) (string, error) {
+	switch call.Name {
+	case "read_file":
+		return w.readFile(stringArg(call.Args, "path"), intArg(call.Args, "start_line"), intArg(call.Args, "end_line"))
+	case "list_directory":
+		return w.listDirectory(stringArg(call.Args, "path"))
+	case "grep":
+		return w.grep(ctx, stringArg(call.Args, "pattern"), stringArg(call.Args, "path"))
+	case "git_show":
+		return w.gitShow(ctx, stringArg(call.Args, "revision"), stringArg(call.Args, "path"))
+	}
+	return "", fmt.Errorf("unknown tool %q", call.Name)
+}
+
+// resolve maps a workspace-relative path to an absolute path inside the workspace,
+// rejecting escapes through ".." or symbolic links
+func (w *Workspace) resolve(name string) (string, error) {
+	if name == "" {
+		name = "."
+	}
+	return w.check(name, filepath.Join(w.root, filepath.Clean("/"+filepath.ToSlash(name))))
+}
+
+// check resolves the symbolic links of path, a path under the workspace root,
+// and rejects it if it leaves the workspace or if buildContext would leave it
+// out of the review context, like the token cache. name identifies the path in errors.
+func (w *Workspace) check(name, path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: no such file or directory", name)
+	}
+	if resolved != w.root && !strings.HasPrefix(resolved, w.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideWorkspace, name)
+	}
+	if w.file != "" && resolved != w.root && resolved != w.file {
+		return "", fmt.Errorf("%w: %s", ErrOutsideWorkspace, name)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("%s: no such file or directory", name)
+	}
+	// Both the link and its target must be part of the review context
+	for _, p := range []string{path, resolved} {
+		if rel, err := filepath.Rel(w.root, p); err == nil && excludedFromContext(rel, info.IsDir()) {
+			return "", fmt.Errorf("%w: %s", ErrExcludedPath, name)
+		}
+	}
+	if w.tokenCache != "" {
+		if cache, err := os.Stat(w.tokenCache); err == nil && os.SameFile(info, cache) {
+			return "", fmt.Errorf("%w: %s", ErrExcludedPath, name)
+		}
+	}
+	return resolved, nil
+}
+
+// readFile returns a text file, optionally limited to a line range
+func (w *Workspace) readFile(name string, startLine, endLine int) (string, error) {
+	path, err := w.resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, errors.Unwrap(err))
+	}
+	if isBinary(data) {
+		return "", fmt.Errorf("%s is a binary file", name)
+	}
+
+	if startLine <= 0 && endLine <= 0 {
+		return string(data), nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if startLine <= 0 {
+		startLine = 1
+	}
+	if endLine <= 0 || endLine > len(lines) {
+		endLine = len(lines)
+	}
+	if startLine > endLine {
+		return "", fmt.Errorf("%s has %d lines", name, len(lines))
+	}
+	return strings.Join(lines[startLine-1:endLine], ""), nil
+}
+
+// listDirectory lists a directory, marking subdirectories with a trailing slash
+func (w *Workspace) listDirectory(name string) (string, error) {
+	path, err := w.resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, errors.Unwrap(err))
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		if _, err := w.check(entry.Name(), filepath.Join(path, entry.Name())); err != nil {
+			continue
+		}
+		sb.WriteString(entry.Name())
+		if entry.IsDir() {
+			sb.WriteString("/")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// grep searches text files for a regular expression
+func (w *Workspace) grep(ctx context.Context, pattern, name string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	path, err := w.resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	walkErr := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.root, file)
+		if entry.IsDir() {
+			if file != path && (w.file != "" || excludedFromContext(rel, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symbolic links are followed only to files in the review context
+		resolved, err := w.check(rel, file)
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(resolved)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			if re.Match(scanner.Bytes()) {
+				matches = append(matches, fmt.Sprintf("%s:%d: %s", filepath.ToSlash(rel), lineNo, scanner.Text()))
+				if len(matches) >= maxGrepMatches {
+					return filepath.SkipAll
+				}
+			}
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return "", walkErr
+	}
+
+	if len(matches) == 0 {
+		return "no matches", nil
+	}
+	sort.Strings(matches)
+	return strings.Join(matches, "\n"), nil
+}
+
+// gitShow shows a commit, limited to the workspace or a path inside it
+func (w *Workspace) gitShow(ctx context.Context, revision, name string) (string, error) {
+	// Reject option injection such as --output=<file> and blob syntax such as
+	// HEAD:secret.txt, which ignores the pathspec
+	if revision == "" || strings.HasPrefix(revision, "-") || strings.Contains(revision, ":") {
+		return "", fmt.Errorf("invalid revision %q", revision)
+	}
+
+	pathspec, err := w.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if w.file != "" {
+		pathspec = w.file
+	}
+
+	git := NewGitAnalyzer(w.root, w.debug)
+	git.logger = w.logger
+
+	// Show only commits, never a tree or tag object outside the pathspec
+	commit, err := git.runGitCommand(ctx, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%s is not a commit", revision)
+	}
+	args := append([]string{"show", "--no-color", "--no-ext-diff", strings.TrimSpace(commit), "--", pathspec}, w.contextExcludes()...)
+	return git.runGitCommand(ctx, args...)
+}
+
+// contextExcludes returns pathspecs that keep what buildContext leaves out of
+// the review context out of git output: hidden files, excluded directories and
+// the token cache
+func (w *Workspace) contextExcludes() []string {
+	specs := []string{":(exclude,glob)**/.*", ":(exclude,glob)**/.*/**"}
+
+	dirs := make([]string, 0, len(excludedDirs))
+	for dir := range excludedDirs {
+		if !strings.HasPrefix(dir, ".") {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		specs = append(specs, ":(exclude,glob)**/"+dir+"/**")
+	}
+
+	if w.tokenCache != "" {
+		if cache, err := filepath.EvalSymlinks(w.tokenCache); err == nil {
+			if rel, err := filepath.Rel(w.root, cache); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				specs = append(specs, ":(exclude,literal)"+filepath.ToSlash(rel))
+			}
+		}
+	}
+	return specs
+}
+
+// isBinary reports whether data looks like a binary file
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// stringArg returns a string argument of a function call
+func stringArg(args map[string]any, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// intArg returns an integer argument of a function call (JSON numbers decode as float64)
+func intArg(args map[string]any, name string) int {
+	switch value := args[name].(type) {
+	case float64:
+		return int(value)
+	case string:
+		var n int
+		fmt.Sscanf(value, "%d", &n)
+		return n
+	}
+	return 0
+}
+
+// formatArgs formats function call arguments for the [TOOL] log
+func formatArgs(args map[string]any) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, args[key]))
+	}
+	return truncateString(strings.Join(parts, ", "), 120)
+}
@@ -0,0 +1,348 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JimmaaBinyamin/drone-gemini-plugin/plugin/geminitest"
+)
+
+func TestWorkspace_Resolve(t *testing.T) {
+	outside := writeTestFiles(t, map[string]string{"secret.txt": "token"})
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":      "package main\n",
+		"pkg/util.go":  "package pkg\n",
+		".git/config":  "[core]\n",
+		".env":         "TOKEN=secret\n",
+		"build/out.go": "package build\n",
+	})
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, ".env"), filepath.Join(dir, "env.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "main.go"), filepath.Join(dir, ".main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"file", "main.go", nil},
+		{"nested file", "pkg/util.go", nil},
+		{"root", ".", nil},
+		{"empty means root", "", nil},
+		{"parent stays in root", "../main.go", nil},
+		{"absolute stays in root", "/pkg/util.go", nil},
+		{"symlink escape", "link.txt", ErrOutsideWorkspace},
+		{"git directory", ".git/config", ErrExcludedPath},
+		{"hidden file", ".env", ErrExcludedPath},
+		{"excluded directory", "build/out.go", ErrExcludedPath},
+		{"symlink to hidden file", "env.txt", ErrExcludedPath},
+		{"hidden symlink", ".main.go", ErrExcludedPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w.resolve(tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolve(%q) error = %v, want %v", tt.path, err, tt.wantErr)
+			}
+			if err == nil && !strings.HasPrefix(got, w.root) {
+				t.Errorf("resolve(%q) = %q, outside %q", tt.path, got, w.root)
+			}
+		})
+	}
+}
+
+func TestWorkspace_Call(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":     "package main\n\nfunc main() {\n\tpanic(\"todo\")\n}\n",
+		"pkg/util.go": "package pkg\n\n// TODO: remove\n",
+		"logo.png":    "\x89PNG\x00\x00",
+		".git/HEAD":   "TODO: hidden\n",
+		".env":        "TODO=hidden\n",
+		"dist/app.js": "// TODO: generated\n",
+	})
+	outside := writeTestFiles(t, map[string]string{"secret.txt": "TODO: outside\n"})
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	w, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		call       FunctionCall
+		wantOutput string
+		wantError  string
+	}{
+		{
+			name:       "read file",
+			call:       FunctionCall{Name: "read_file", Args: map[string]any{"path": "pkg/util.go"}},
+			wantOutput: "package pkg\n\n// TODO: remove\n",
+		},
+		{
+			name:       "read line range",
+			call:       FunctionCall{Name: "read_file", Args: map[string]any{"path": "main.go", "start_line": float64(3), "end_line": float64(4)}},
+			wantOutput: "func main() {\n\tpanic(\"todo\")\n",
+		},
+		{
+			name:      "read binary file",
+			call:      FunctionCall{Name: "read_file", Args: map[string]any{"path": "logo.png"}},
+			wantError: "binary",
+		},
+		{
+			name:      "read missing file",
+			call:      FunctionCall{Name: "read_file", Args: map[string]any{"path": "missing.go"}},
+			wantError: "no such file",
+		},
+		{
+			name:      "read hidden file",
+			call:      FunctionCall{Name: "read_file", Args: map[string]any{"path": ".env"}},
+			wantError: "excluded from the review context",
+		},
+		{
+			name:       "list directory",
+			call:       FunctionCall{Name: "list_directory", Args: map[string]any{}},
+			wantOutput: "logo.png\nmain.go\npkg/\n",
+		},
+		{
+			name:       "grep skips hidden, excluded and outside files",
+			call:       FunctionCall{Name: "grep", Args: map[string]any{"pattern": "TODO"}},
+			wantOutput: "pkg/util.go:3: // TODO: remove",
+		},
+		{
+			name:      "grep invalid pattern",
+			call:      FunctionCall{Name: "grep", Args: map[string]any{"pattern": "("}},
+			wantError: "invalid pattern",
+		},
+		{
+			name:      "git show option injection",
+			call:      FunctionCall{Name: "git_show", Args: map[string]any{"revision": "--output=/tmp/x"}},
+			wantError: "invalid revision",
+		},
+		{
+			name:      "unknown tool",
+			call:      FunctionCall{Name: "write_file"},
+			wantError: "unknown tool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := w.Call(context.Background(), tt.call)
+			if resp.Name != tt.call.Name {
+				t.Errorf("Name = %q, want %q", resp.Name, tt.call.Name)
+			}
+			if tt.wantError != "" {
+				msg, _ := resp.Response["error"].(string)
+				if !strings.Contains(msg, tt.wantError) {
+					t.Errorf("error = %q, want it to contain %q", msg, tt.wantError)
+				}
+				return
+			}
+			if got := resp.Response["output"]; got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestWorkspace_FileTarget(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.go":     "package main\n\n// TODO: fix\n",
+		"sibling.go":  "package main\n\n// TODO: secret\n",
+		"pkg/util.go": "package pkg\n\n// TODO: secret\n",
+	})
+
+	w, err := NewWorkspace(filepath.Join(dir, "main.go"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		call       FunctionCall
+		wantOutput string
+		wantError  string
+	}{
+		{
+			name:       "read target",
+			call:       FunctionCall{Name: "read_file", Args: map[string]any{"path": "main.go"}},
+			wantOutput: "package main\n\n// TODO: fix\n",
+		},
+		{
+			name:      "read sibling",
+			call:      FunctionCall{Name: "read_file", Args: map[string]any{"path": "sibling.go"}},
+			wantError: "outside the workspace",
+		},
+		{
+			name:       "list directory",
+			call:       FunctionCall{Name: "list_directory", Args: map[string]any{}},
+			wantOutput: "main.go\n",
+		},
+		{
+			name:       "grep",
+			call:       FunctionCall{Name: "grep", Args: map[string]any{"pattern": "TODO"}},
+			wantOutput: "main.go:3: // TODO: fix",
+		},
+		{
+			name:      "grep subdirectory",
+			call:      FunctionCall{Name: "grep", Args: map[string]any{"pattern": "TODO", "path": "pkg"}},
+			wantError: "outside the workspace",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := w.Call(context.Background(), tt.call)
+			if tt.wantError != "" {
+				msg, _ := resp.Response["error"].(string)
+				if !strings.Contains(msg, tt.wantError) {
+					t.Errorf("error = %q, want it to contain %q", msg, tt.wantError)
+				}
+				return
+			}
+			if got := resp.Response["output"]; got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestWorkspace_GitShow(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := writeTestFiles(t, map[string]string{
+		"secret.txt":         "top secret\n",
+		"sub/main.go":        "package main\n",
+		"sub/other.go":       "package main // sibling\n",
+		"sub/.env":           "TOKEN=secret\n",
+		"sub/vendor/v.go":    "package v // secret\n",
+		"sub/pkg/.cfg/a.txt": "secret\n",
+		"sub/token.json":     `{"access_token":"secret"}`,
+	})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	w, err := NewWorkspace(filepath.Join(repo, "sub"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.tokenCache = filepath.Join(repo, "sub", "token.json")
+
+	tests := []struct {
+		name       string
+		revision   string
+		wantOutput string
+		wantError  string
+	}{
+		{name: "commit limited to workspace", revision: "HEAD", wantOutput: "sub/main.go"},
+		{name: "blob outside pathspec", revision: "HEAD:secret.txt", wantError: "invalid revision"},
+		{name: "tree", revision: "HEAD^{tree}", wantError: "not a commit"},
+		{name: "unknown revision", revision: "HEAD~5", wantError: "not a commit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := w.Call(context.Background(), FunctionCall{Name: "git_show", Args: map[string]any{"revision": tt.revision}})
+			if tt.wantError != "" {
+				msg, _ := resp.Response["error"].(string)
+				if !strings.Contains(msg, tt.wantError) {
+					t.Errorf("error = %q, want it to contain %q", msg, tt.wantError)
+				}
+				return
+			}
+			output, _ := resp.Response["output"].(string)
+			if !strings.Contains(output, tt.wantOutput) || strings.Contains(output, "secret") {
+				t.Errorf("output = %q, want %q without files outside the review context", output, tt.wantOutput)
+			}
+		})
+	}
+
+	// A file target limits the diff to that file
+	fileWorkspace, err := NewWorkspace(filepath.Join(repo, "sub", "main.go"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := fileWorkspace.Call(context.Background(), FunctionCall{Name: "git_show", Args: map[string]any{"revision": "HEAD"}})
+	if output, _ := resp.Response["output"].(string); !strings.Contains(output, "sub/main.go") || strings.Contains(output, "other.go") {
+		t.Errorf("output = %q, want the diff of the target file only", output)
+	}
+}
+
+func TestGeminiClient_BuiltinTools(t *testing.T) {
+	srv := geminitest.NewServer()
+	defer srv.Close()
+
+	cfg := execConfig(t)
+	cfg.CodeExecution = true
+	cfg.URLContext = true
+	if _, err := NewGeminiClient(&cfg, WithTransport(srv.Transport())).GenerateContent(context.Background()); err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	requests := srv.RequestsFor(geminitest.MethodGenerateContent)
+	if len(requests) != 1 {
+		t.Fatalf("generateContent requests = %d, want 1", len(requests))
+	}
+	var body map[string]any
+	if err := requests[0].JSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	tools, _ := json.Marshal(body["tools"])
+	if string(tools) != `[{"codeExecution":{}},{"urlContext":{}}]` {
+		t.Errorf("tools = %s, want codeExecution and urlContext", tools)
+	}
+}
+
+func TestExtractText_CodeExecution(t *testing.T) {
+	resp := &GenerateContentResponse{Candidates: []Candidate{{Content: Content{Parts: []Part{
+		{Text: "Checking the pattern:"},
+		{ExecutableCode: &ExecutableCode{Language: "PYTHON", Code: "import re\nprint(bool(re.match(r'^a+$', 'aaa')))\n"}},
+		{CodeExecutionResult: &CodeExecutionResult{Outcome: "OUTCOME_OK", Output: "True\n"}},
+		{ExecutableCode: &ExecutableCode{Language: "PYTHON", Code: "while True: pass"}},
+		{CodeExecutionResult: &CodeExecutionResult{Outcome: "OUTCOME_DEADLINE_EXCEEDED"}},
+		{Text: "The regex is correct."},
+	}}}}}
+
+	want := "Checking the pattern:" +
+		"\n```python\nimport re\nprint(bool(re.match(r'^a+$', 'aaa')))\n```\n" +
+		"\n```\nTrue\n```\n" +
+		"\n```python\nwhile True: pass\n```\n" +
+		"\nCode execution failed: DEADLINE_EXCEEDED\n" +
+		"The regex is correct."
+
+	text, _ := extractText(resp)
+	if text != want {
+		t.Errorf("extractText() = %q, want %q", text, want)
+	}
+}
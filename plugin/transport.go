@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// NewHTTPClient builds the HTTP client shared by every outbound call of the plugin
+// (model API, token exchange), applying the proxy, CA bundle and client certificate
+// settings. Without any of these settings it behaves like http.DefaultClient.
+func NewHTTPClient(cfg *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Explicit proxy setting; otherwise HTTPS_PROXY/NO_PROXY from the environment apply
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid proxy URL: %v", ErrInvalidTLSConfig, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.CABundle != "" || cfg.ClientCert != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if cfg.CABundle != "" {
+			pool, err := loadCertPool(cfg.CABundle)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if cfg.ClientCert != "" {
+			cert, err := loadClientCertificate(cfg.ClientCert, cfg.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCertPool returns the system roots extended with the given CA bundle
+func loadCertPool(bundle string) (*x509.CertPool, error) {
+	pemData, err := readPEM(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read CA bundle: %v", ErrInvalidTLSConfig, err)
+	}
+
+	// Keep public roots trusted so the private CA is additive
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("%w: CA bundle contains no PEM certificates", ErrInvalidTLSConfig)
+	}
+	return pool, nil
+}
+
+// loadClientCertificate loads the mTLS client certificate and private key
+func loadClientCertificate(certValue, keyValue string) (tls.Certificate, error) {
+	certPEM, err := readPEM(certValue)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: failed to read client certificate: %v", ErrInvalidTLSConfig, err)
+	}
+
+	keyPEM, err := readPEM(keyValue)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: failed to read client key: %v", ErrInvalidTLSConfig, err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: invalid client certificate or key: %v", ErrInvalidTLSConfig, err)
+	}
+	return cert, nil
+}
+
+// readPEM returns inline PEM content as-is, or reads it from a file path
+func readPEM(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}